@@ -0,0 +1,201 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/irmine/goraklib/server"
+)
+
+const (
+	// ProtocolVersion is the RakNet protocol version sent to servers when dialing.
+	ProtocolVersion = 9
+	// DefaultTimeout is the default duration after which dialing a server fails.
+	DefaultTimeout = time.Second * 10
+)
+
+var (
+	// TimedOut is an error returned by Dial if the server did not complete the handshake in time.
+	TimedOut = errors.New("raknet handshake timed out")
+	// InvalidReply is an error returned by Dial if the server replied with a malformed handshake packet.
+	InvalidReply = errors.New("invalid handshake reply")
+	// ServerFull is an error returned by Dial if the server has no free incoming connections.
+	ServerFull = errors.New("server has no free incoming connections")
+	// Banned is an error returned by Dial if the server banned the address of the dialer.
+	Banned = errors.New("banned from server")
+)
+
+// IncompatibleProtocolError is an error returned by Dial if the server does not support
+// the RakNet protocol version of the dialer.
+type IncompatibleProtocolError struct {
+	// Protocol is the RakNet protocol version the server replied with.
+	Protocol byte
+}
+
+// Error returns the error message of the incompatible protocol error.
+func (err *IncompatibleProtocolError) Error() string {
+	return fmt.Sprintf("incompatible raknet protocol version, server uses protocol %v", err.Protocol)
+}
+
+// mtuSizes are the MTU sizes tried during MTU discovery, from large to small.
+// The first MTU size the server replies to is used for the session.
+var mtuSizes = []int16{server.MaximumMTUSize, 1200, 576}
+
+// Dialer holds the options used to dial a RakNet server.
+// The zero value of a Dialer is ready to use.
+type Dialer struct {
+	// Timeout is the maximum duration the full handshake may take.
+	// DefaultTimeout is used if the timeout is zero.
+	Timeout time.Duration
+	// Protocol is the RakNet protocol version sent in the open connection request.
+	// ProtocolVersion is used if the protocol is zero.
+	Protocol byte
+	// Logger is the logger events of the session are logged to.
+	// The default logger of the manager is used if the logger is nil.
+	Logger *slog.Logger
+
+	// PacketFunction gets called once an encapsulated packet is fully processed.
+	// It is set on the manager of the session before the handshake starts,
+	// so no packets sent by the server right after connecting are missed.
+	PacketFunction func(packet []byte, session *server.Session)
+	// DisconnectFunction gets called with the session and the reason once it gets disconnected.
+	// The server disconnecting the session is reported as DisconnectReasonClientQuit,
+	// as the other end of the session disconnected itself. If the server does not accept the connection
+	// request in time, it is called with DisconnectReasonTimeout for the session that was never returned.
+	DisconnectFunction func(session *server.Session, reason server.DisconnectReason)
+}
+
+// Dial dials a RakNet server on the given address using a zero Dialer.
+// The address should be of the form "host:port".
+func Dial(address string) (*server.Session, error) {
+	return Dialer{}.Dial(address)
+}
+
+// Dial dials a RakNet server on the given address.
+// Dial sends both open connection requests and the connection request,
+// and returns once the new incoming connection has been sent.
+// The session returned has the same send and receive semantics as a session of a server.
+func (dialer Dialer) Dial(address string) (*server.Session, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialPacketConn(conn, address)
+}
+
+// DialPacketConn dials a RakNet server on the given address over the given packet connection.
+// The connection may be any net.PacketConn, as long as it can write to and read from a *net.UDPAddr,
+// or an address of which the string is of the form "host:port".
+// The connection is closed if dialing fails, or once the session returned gets disconnected.
+func (dialer Dialer) DialPacketConn(conn net.PacketConn, address string) (*server.Session, error) {
+	if dialer.Timeout == 0 {
+		dialer.Timeout = DefaultTimeout
+	}
+	if dialer.Protocol == 0 {
+		dialer.Protocol = ProtocolVersion
+	}
+	deadline := time.Now().Add(dialer.Timeout)
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	manager := server.NewManager()
+	manager.Server.PacketConn = conn
+	if dialer.Logger != nil {
+		manager.Logger = dialer.Logger
+	}
+	if dialer.PacketFunction != nil {
+		manager.PacketFunction = dialer.PacketFunction
+	}
+	closed := make(chan struct{})
+	manager.DisconnectFunction = func(session *server.Session, reason server.DisconnectReason) {
+		if dialer.DisconnectFunction != nil {
+			dialer.DisconnectFunction(session, reason)
+		}
+		manager.Stop()
+		close(closed)
+	}
+	connected := make(chan *server.Session, 1)
+	manager.ConnectFunction = func(session *server.Session) {
+		connected <- session
+	}
+
+	handshake := NewHandshake(addr, dialer.Protocol, manager.ServerId)
+	if err := openConnection(conn, handshake, deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	pending := handshake.Connect(manager)
+	manager.Run()
+
+	select {
+	case session := <-connected:
+		return session, nil
+	case <-time.After(time.Until(deadline)):
+		// The session is closed before the manager stops, so that it is never returned,
+		// even if it connects right now, and so that the DisconnectFunction is called for it.
+		pending.Abort(server.DisconnectReasonTimeout)
+		<-closed
+		return nil, TimedOut
+	}
+}
+
+// openConnection executes the unconnected part of the handshake over the packet connection.
+// Requests are resent every HandshakeRetryInterval until the server replies, or until the deadline passes.
+func openConnection(conn net.PacketConn, handshake *Handshake, deadline time.Time) error {
+	buffer := make([]byte, 2048)
+	for !handshake.Done() {
+		if _, err := conn.WriteTo(handshake.Request(), handshake.Addr); err != nil {
+			return err
+		}
+		attemptDeadline := time.Now().Add(HandshakeRetryInterval)
+		if attemptDeadline.After(deadline) {
+			attemptDeadline = deadline
+		}
+		conn.SetReadDeadline(attemptDeadline)
+
+		progressed := false
+		for !progressed {
+			n, sender, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			if n == 0 || !sameAddress(sender, handshake.Addr) {
+				continue
+			}
+			progressed, err = handshake.HandleReply(append([]byte{}, buffer[:n]...))
+			if err != nil {
+				return err
+			}
+		}
+		if progressed {
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return TimedOut
+		}
+		if err := handshake.Retry(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameAddress checks if an address read from a packet connection is the UDP address of the server.
+func sameAddress(sender net.Addr, addr *net.UDPAddr) bool {
+	if udpAddr, ok := sender.(*net.UDPAddr); ok {
+		return udpAddr.IP.Equal(addr.IP) && udpAddr.Port == addr.Port
+	}
+	return sender.String() == addr.String()
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+const (
+	// HandshakeRetryInterval is the duration after which a handshake request is resent if no reply arrived.
+	HandshakeRetryInterval = time.Millisecond * 500
+	// handshakeAttempts is the amount of times a handshake request is sent before giving up on it.
+	handshakeAttempts = 4
+)
+
+// A Handshake is the unconnected part of the handshake with a server, which exchanges both open connection requests.
+// A handshake does not read or write packets itself, so that it can be driven over any transport:
+// the Request should be sent to the server, every packet of the server passed to HandleReply,
+// and Retry called every HandshakeRetryInterval in which no reply arrived.
+// Connect creates the session once the handshake is done.
+type Handshake struct {
+	// Addr is the UDP address of the server.
+	Addr *net.UDPAddr
+	// Protocol is the RakNet protocol version sent in the open connection request 1.
+	Protocol byte
+	// ClientId is the client ID sent to the server.
+	ClientId int64
+
+	mtuIndex int
+	attempts int
+	reply1   *protocol.OpenConnectionReply1
+	mtuSize  int16
+}
+
+// NewHandshake returns a new handshake with the server on the given address.
+func NewHandshake(addr *net.UDPAddr, protocol byte, clientId int64) *Handshake {
+	return &Handshake{Addr: addr, Protocol: protocol, ClientId: clientId}
+}
+
+// Done checks if the handshake is done, after which Connect may be called.
+func (handshake *Handshake) Done() bool {
+	return handshake.mtuSize != 0
+}
+
+// MTUSize returns the MTU size agreed on with the server once the handshake is done.
+func (handshake *Handshake) MTUSize() int16 {
+	return handshake.mtuSize
+}
+
+// Request returns the request that should currently be sent to the server.
+// The open connection request 1 is sent with decreasing MTU sizes until the server replies,
+// after which the open connection request 2 is sent.
+func (handshake *Handshake) Request() []byte {
+	if handshake.reply1 == nil {
+		request := protocol.NewOpenConnectionRequest1()
+		request.Protocol = handshake.Protocol
+		request.MtuSize = mtuSizes[handshake.mtuIndex]
+		request.Encode()
+		return request.Buffer
+	}
+	request := protocol.NewOpenConnectionRequest2()
+	request.Security = handshake.reply1.Security
+	request.Cookie = handshake.reply1.Cookie
+	request.ServerAddress = handshake.Addr.IP.String()
+	request.ServerPort = uint16(handshake.Addr.Port)
+	request.MtuSize = handshake.reply1.MtuSize
+	request.ClientId = handshake.ClientId
+	request.Encode()
+	return request.Buffer
+}
+
+// HandleReply handles a packet sent by the server.
+// HandleReply returns true if the handshake progressed, in which case the next Request should be sent immediately,
+// unless the handshake is done. Packets that are not a reply to the current request are ignored.
+// An IncompatibleProtocolError is returned if the server replies with an incompatible protocol version,
+// ServerFull or Banned if the server refuses the connection, and InvalidReply if the reply is malformed.
+func (handshake *Handshake) HandleReply(buffer []byte) (bool, error) {
+	if len(buffer) == 0 || handshake.Done() {
+		return false, nil
+	}
+	switch buffer[0] {
+	case protocol.IdOpenConnectionReply1:
+		if handshake.reply1 != nil {
+			return false, nil
+		}
+		reply := protocol.NewOpenConnectionReply1()
+		reply.SetBuffer(buffer)
+		if err := reply.Decode(); err != nil {
+			return false, InvalidReply
+		}
+		handshake.reply1 = reply
+		handshake.attempts = 0
+		return true, nil
+	case protocol.IdOpenConnectionReply2:
+		if handshake.reply1 == nil {
+			return false, nil
+		}
+		reply := protocol.NewOpenConnectionReply2()
+		reply.SetBuffer(buffer)
+		if err := reply.Decode(); err != nil {
+			return false, InvalidReply
+		}
+		mtuSize := reply.MtuSize
+		if mtuSize < server.MinimumMTUSize {
+			mtuSize = server.MinimumMTUSize
+		} else if mtuSize > server.MaximumMTUSize {
+			mtuSize = server.MaximumMTUSize
+		}
+		handshake.mtuSize = mtuSize
+		return true, nil
+	case protocol.IdIncompatibleProtocolVersion:
+		reply := protocol.NewIncompatibleProtocolVersion()
+		reply.SetBuffer(buffer)
+		if err := reply.Decode(); err != nil {
+			return false, InvalidReply
+		}
+		return false, &IncompatibleProtocolError{reply.Protocol}
+	case protocol.IdNoFreeIncomingConnections:
+		return false, ServerFull
+	case protocol.IdConnectionBanned:
+		return false, Banned
+	}
+	return false, nil
+}
+
+// Retry should be called once no reply arrived within the HandshakeRetryInterval after sending the Request.
+// The request is sent a couple of times, after which the next smaller MTU size is tried for the open connection request 1.
+// Retry returns TimedOut once the server did not reply to any of the attempts.
+func (handshake *Handshake) Retry() error {
+	handshake.attempts++
+	if handshake.attempts < handshakeAttempts {
+		return nil
+	}
+	if handshake.reply1 == nil && handshake.mtuIndex < len(mtuSizes)-1 {
+		handshake.mtuIndex++
+		handshake.attempts = 0
+		return nil
+	}
+	return TimedOut
+}
+
+// Connect creates a session with the server for the manager once the handshake is done,
+// and sends the connection request. The ConnectFunction of the manager is called once the server accepted it.
+// The manager should be the manager that sent the requests of the handshake.
+func (handshake *Handshake) Connect(manager *server.Manager) *server.Session {
+	session := server.NewDialedSession(handshake.Addr, handshake.mtuSize, manager)
+	manager.Sessions.AddSession(session)
+
+	request := protocol.NewConnectionRequest()
+	request.ClientId = uint64(handshake.ClientId)
+	request.PingSendTime = uint64(manager.Clock.Now().UnixNano() / int64(time.Millisecond))
+	session.SendPacket(request, protocol.ReliabilityReliable, server.PriorityImmediate, 0)
+	return session
+}
@@ -0,0 +1,197 @@
+package impair
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/irmine/goraklib/server"
+)
+
+// maximumPacketSize is the size of the buffer packets are read into before being impaired.
+const maximumPacketSize = 65535
+
+// A Conn is a net.PacketConn wrapping another packet connection, impairing the packets written to and read from it.
+// A Conn can be put in front of a running manager using Manager.Serve, or be given to a dialer using DialPacketConn,
+// so that sessions can be tested over bad networks.
+type Conn struct {
+	net.PacketConn
+
+	clock    server.Clock
+	virtual  bool
+	outbound *link
+	inbound  *link
+
+	// releaseMutex makes sure due packets are written in the order they are due.
+	releaseMutex sync.Mutex
+	wake         chan struct{}
+	closed       chan struct{}
+	closeOnce    sync.Once
+
+	readMutex     sync.Mutex
+	buffer        []byte
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+}
+
+// NewConn returns a new connection wrapping the given packet connection, which impairs packets written to it
+// using the outbound config, and packets read from it using the inbound config.
+// Delayed packets are written by a goroutine of the connection once due, until the connection is closed.
+func NewConn(conn net.PacketConn, outbound Config, inbound Config) *Conn {
+	impaired := newConn(conn, outbound, inbound, server.SystemClock{})
+	go impaired.releaseLoop()
+	return impaired
+}
+
+// NewVirtualConn returns a new connection wrapping the given packet connection, which impairs packets written to it
+// using the outbound config. Delays are measured using the given clock, and delayed packets are only written
+// when Release is called, so that the connection can be driven by a virtual clock deterministically.
+// Packets read from a virtual connection are not impaired.
+func NewVirtualConn(conn net.PacketConn, outbound Config, clock server.Clock) *Conn {
+	impaired := newConn(conn, outbound, Config{}, clock)
+	impaired.virtual = true
+	return impaired
+}
+
+// newConn returns a new connection impairing packets with the given configs and clock.
+func newConn(conn net.PacketConn, outbound Config, inbound Config, clock server.Clock) *Conn {
+	return &Conn{
+		PacketConn: conn,
+		clock:      clock,
+		outbound:   newLink(outbound),
+		inbound:    newLink(inbound),
+		wake:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+		buffer:     make([]byte, maximumPacketSize),
+	}
+}
+
+// WriteTo sends a packet over the outbound link of the connection. Packets that are not delayed
+// are written immediately, while delayed packets are written once due. Packets lost do not return an error.
+func (conn *Conn) WriteTo(buffer []byte, addr net.Addr) (int, error) {
+	select {
+	case <-conn.closed:
+		return 0, &net.OpError{Op: "write", Net: "impair", Addr: conn.LocalAddr(), Err: net.ErrClosed}
+	default:
+	}
+	conn.outbound.send(Packet{addr, append([]byte{}, buffer...)}, conn.clock.Now())
+	conn.Release()
+	if !conn.virtual {
+		select {
+		case conn.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(buffer), nil
+}
+
+// Release writes all packets of the outbound link that are due, and returns the amount of packets written.
+// Release only needs to be called for virtual connections, every time their clock advanced.
+// Errors writing to the wrapped connection are ignored, and the packets are lost.
+func (conn *Conn) Release() int {
+	conn.releaseMutex.Lock()
+	defer conn.releaseMutex.Unlock()
+	released := 0
+	for {
+		packet, ok := conn.outbound.receive(conn.clock.Now())
+		if !ok {
+			return released
+		}
+		conn.PacketConn.WriteTo(packet.Buffer, packet.Addr)
+		released++
+	}
+}
+
+// ReadFrom reads a packet from the inbound link of the connection into the buffer. Packets read from the wrapped
+// connection are impaired first, so that ReadFrom may return a packet read earlier, or block until a delayed
+// packet is due. ReadFrom must not be called concurrently.
+func (conn *Conn) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	if conn.virtual {
+		return conn.PacketConn.ReadFrom(buffer)
+	}
+	conn.readMutex.Lock()
+	defer conn.readMutex.Unlock()
+	for {
+		now := conn.clock.Now()
+		if packet, ok := conn.inbound.receive(now); ok {
+			return copy(buffer, packet.Buffer), packet.Addr, nil
+		}
+		conn.deadlineMutex.Lock()
+		deadline := conn.readDeadline
+		if !deadline.IsZero() && !now.Before(deadline) {
+			conn.deadlineMutex.Unlock()
+			return 0, nil, &net.OpError{Op: "read", Net: "impair", Addr: conn.LocalAddr(), Err: os.ErrDeadlineExceeded}
+		}
+		// The wrapped connection is read until the next delayed packet is due, if it is due before the deadline.
+		if due, ok := conn.inbound.nextDue(); ok && (deadline.IsZero() || due.Before(deadline)) {
+			deadline = due
+		}
+		conn.PacketConn.SetReadDeadline(deadline)
+		conn.deadlineMutex.Unlock()
+
+		n, addr, err := conn.PacketConn.ReadFrom(conn.buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return 0, nil, err
+		}
+		conn.inbound.send(Packet{addr, append([]byte{}, conn.buffer[:n]...)}, conn.clock.Now())
+	}
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (conn *Conn) SetDeadline(t time.Time) error {
+	if err := conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of ReadFrom calls, including calls currently blocked.
+// A zero time disables the deadline.
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	if conn.virtual {
+		return conn.PacketConn.SetReadDeadline(t)
+	}
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+	conn.readDeadline = t
+	// Blocked reads are interrupted, so that they continue with the new deadline.
+	return conn.PacketConn.SetReadDeadline(time.Unix(1, 0))
+}
+
+// Close closes the connection and the connection it wraps.
+// Packets still delayed are never written.
+func (conn *Conn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+	return conn.PacketConn.Close()
+}
+
+// Stats returns the amount of packets impaired by the connection, for both written and read packets.
+func (conn *Conn) Stats() (outbound Stats, inbound Stats) {
+	return conn.outbound.getStats(), conn.inbound.getStats()
+}
+
+// releaseLoop writes the packets of the outbound link once due, until the connection is closed.
+func (conn *Conn) releaseLoop() {
+	for {
+		conn.Release()
+		wait := time.Hour
+		if due, ok := conn.outbound.nextDue(); ok {
+			wait = time.Until(due)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-conn.wake:
+		case <-conn.closed:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
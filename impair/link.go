@@ -0,0 +1,241 @@
+// Package impair implements a packet connection that impairs the packets passing through it,
+// simulating bad networks with loss, reordering, duplication, latency, limited bandwidth and MTU black holes.
+package impair
+
+import (
+	"container/heap"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderSize is the size of the IP and UDP headers of a packet, which count towards the MTU and bandwidth.
+	HeaderSize = 28
+	// DefaultBurstLength is the amount of packets lost in a burst if the burst length is zero.
+	DefaultBurstLength = 5
+	// DefaultReorderDelay is the delay of reordered packets if the reorder delay is zero.
+	DefaultReorderDelay = time.Millisecond * 20
+)
+
+// A Config holds the impairments of packets sent in a single direction.
+// The zero value of a Config does not impair packets at all.
+type Config struct {
+	// Loss is the probability of a packet getting lost, from 0 to 1.
+	Loss float64
+	// BurstLoss is the probability of a packet starting a burst of loss, from 0 to 1.
+	// The packet and the packets after it are lost until BurstLength packets were lost.
+	BurstLoss float64
+	// BurstLength is the amount of packets lost in a row in a burst of loss.
+	// DefaultBurstLength is used if the burst length is zero.
+	BurstLength int
+	// Duplicate is the probability of a packet being delivered twice, from 0 to 1.
+	Duplicate float64
+	// Reorder is the probability of a packet being delayed by the ReorderDelay, from 0 to 1,
+	// so that it arrives after packets sent after it.
+	Reorder float64
+	// ReorderDelay is the delay added to reordered packets.
+	// DefaultReorderDelay is used if the reorder delay is zero.
+	ReorderDelay time.Duration
+	// Latency is the delay of every packet.
+	Latency time.Duration
+	// Jitter is the maximum random delay added to the latency of every packet.
+	// Packets sent within the jitter of each other may arrive out of order.
+	Jitter time.Duration
+	// Bandwidth is the maximum amount of bytes sent per second, including headers.
+	// Packets sent faster get queued, and the bandwidth is unlimited if zero.
+	Bandwidth int
+	// QueueSize is the maximum amount of bytes queued when the bandwidth is exceeded.
+	// Packets that do not fit in the queue are dropped, and the queue is unlimited if zero.
+	QueueSize int
+	// MTU is the maximum size of packets, including headers. Larger packets are dropped silently,
+	// like they would be by a path with an MTU black hole, and the size is unlimited if zero.
+	MTU int
+	// Seed is the seed of the random impairments. Packets sent at the same times
+	// over links with the same config and seed are always impaired the same.
+	Seed int64
+}
+
+// Stats holds the amount of packets impaired in a single direction.
+type Stats struct {
+	// Sent is the amount of packets sent, before being impaired.
+	Sent uint64
+	// Lost is the amount of packets lost randomly, including packets lost in bursts.
+	Lost uint64
+	// Blackholed is the amount of packets dropped for exceeding the MTU.
+	Blackholed uint64
+	// Overflowed is the amount of packets dropped because the bandwidth queue was full.
+	Overflowed uint64
+	// Duplicated is the amount of packets delivered twice.
+	Duplicated uint64
+	// Reordered is the amount of packets delayed by the reorder delay.
+	Reordered uint64
+}
+
+// A Packet is a packet sent over a link.
+type Packet struct {
+	// Addr is the address the packet was sent to, or the address it was read from.
+	Addr net.Addr
+	// Buffer is the buffer of the packet.
+	Buffer []byte
+}
+
+// A link impairs the packets sent over it in a single direction, and holds them until they are due.
+// A link is safe for concurrent use.
+type link struct {
+	config Config
+	random *rand.Rand
+
+	mutex     sync.Mutex
+	pending   pendingPackets
+	sequence  uint64
+	burst     int
+	busyUntil time.Time
+	stats     Stats
+}
+
+// newLink returns a new link impairing packets using the given config.
+func newLink(config Config) *link {
+	if config.BurstLength == 0 {
+		config.BurstLength = DefaultBurstLength
+	}
+	if config.ReorderDelay == 0 {
+		config.ReorderDelay = DefaultReorderDelay
+	}
+	return &link{config: config, random: rand.New(rand.NewSource(config.Seed))}
+}
+
+// send sends a packet over the link at the given time. The packet is either dropped,
+// or held until it is due, possibly along with a duplicate of it.
+func (link *link) send(packet Packet, now time.Time) {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	link.stats.Sent++
+	config := link.config
+	size := len(packet.Buffer) + HeaderSize
+
+	if config.MTU != 0 && size > config.MTU {
+		link.stats.Blackholed++
+		return
+	}
+	if link.burst > 0 {
+		link.burst--
+		link.stats.Lost++
+		return
+	}
+	if config.BurstLoss > 0 && link.random.Float64() < config.BurstLoss {
+		link.burst = config.BurstLength - 1
+		link.stats.Lost++
+		return
+	}
+	if config.Loss > 0 && link.random.Float64() < config.Loss {
+		link.stats.Lost++
+		return
+	}
+
+	sent := now
+	if config.Bandwidth != 0 {
+		if link.busyUntil.After(now) {
+			sent = link.busyUntil
+		}
+		queued := int(sent.Sub(now).Seconds() * float64(config.Bandwidth))
+		if config.QueueSize != 0 && queued+size > config.QueueSize {
+			link.stats.Overflowed++
+			return
+		}
+		sent = sent.Add(time.Duration(size) * time.Second / time.Duration(config.Bandwidth))
+		link.busyUntil = sent
+	}
+
+	delay := config.Latency + link.jitter()
+	if config.Reorder > 0 && link.random.Float64() < config.Reorder {
+		delay += config.ReorderDelay
+		link.stats.Reordered++
+	}
+	link.hold(packet, sent.Add(delay))
+
+	if config.Duplicate > 0 && link.random.Float64() < config.Duplicate {
+		link.hold(packet, sent.Add(config.Latency+link.jitter()))
+		link.stats.Duplicated++
+	}
+}
+
+// receive takes the next packet that is due at the given time from the link.
+// False is returned if no packet is due yet.
+func (link *link) receive(now time.Time) (Packet, bool) {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	if len(link.pending) == 0 || link.pending[0].due.After(now) {
+		return Packet{}, false
+	}
+	return heap.Pop(&link.pending).(pendingPacket).packet, true
+}
+
+// nextDue returns the time at which the next packet held by the link is due.
+// False is returned if the link holds no packets.
+func (link *link) nextDue() (time.Time, bool) {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	if len(link.pending) == 0 {
+		return time.Time{}, false
+	}
+	return link.pending[0].due, true
+}
+
+// getStats returns the amount of packets impaired by the link.
+func (link *link) getStats() Stats {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	return link.stats
+}
+
+// hold holds a packet until it is due. Packets due at the same time are received in the order sent.
+func (link *link) hold(packet Packet, due time.Time) {
+	heap.Push(&link.pending, pendingPacket{packet, due, link.sequence})
+	link.sequence++
+}
+
+// jitter returns a random delay within the jitter of the link.
+func (link *link) jitter() time.Duration {
+	if link.config.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(link.random.Int63n(int64(link.config.Jitter)))
+}
+
+// A pendingPacket is a packet held by a link until it is due.
+type pendingPacket struct {
+	packet   Packet
+	due      time.Time
+	sequence uint64
+}
+
+// pendingPackets is a heap of pending packets, ordered by the time they are due.
+type pendingPackets []pendingPacket
+
+func (packets pendingPackets) Len() int {
+	return len(packets)
+}
+
+func (packets pendingPackets) Less(i, j int) bool {
+	if packets[i].due.Equal(packets[j].due) {
+		return packets[i].sequence < packets[j].sequence
+	}
+	return packets[i].due.Before(packets[j].due)
+}
+
+func (packets pendingPackets) Swap(i, j int) {
+	packets[i], packets[j] = packets[j], packets[i]
+}
+
+func (packets *pendingPackets) Push(packet interface{}) {
+	*packets = append(*packets, packet.(pendingPacket))
+}
+
+func (packets *pendingPackets) Pop() interface{} {
+	old := *packets
+	packet := old[len(old)-1]
+	*packets = old[:len(old)-1]
+	return packet
+}
@@ -0,0 +1,150 @@
+package loopback
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConnBacklog is the maximum amount of packets delivered to a connection that have not yet been read.
+// Packets delivered while the backlog is full are dropped, like they would be by a full UDP socket buffer.
+const ConnBacklog = 1024
+
+// A Conn is a net.PacketConn listening on an address of a network.
+// Addresses read from the connection are *net.UDPAddr, and it may write to any address of the form "host:port".
+type Conn struct {
+	network *Network
+	addr    *net.UDPAddr
+	packets chan Packet
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mutex           sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+}
+
+// newConn returns a new connection of the network listening on the given address.
+func newConn(network *Network, addr *net.UDPAddr) *Conn {
+	return &Conn{network: network, addr: addr, packets: make(chan Packet, ConnBacklog), closed: make(chan struct{}), deadlineChanged: make(chan struct{})}
+}
+
+// ReadFrom reads a packet delivered to the connection into the buffer.
+// If the buffer is smaller than the packet, the rest of the packet is discarded.
+// ReadFrom blocks until a packet is delivered, the read deadline passes or the connection is closed.
+func (conn *Conn) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	for {
+		conn.mutex.Lock()
+		deadline, changed := conn.readDeadline, conn.deadlineChanged
+		conn.mutex.Unlock()
+
+		if n, addr, err, ok := conn.read(buffer, deadline, changed); ok {
+			return n, addr, err
+		}
+	}
+}
+
+// read waits for a packet to be delivered until the deadline passes, or until the deadline is changed.
+// False is returned if the deadline was changed, in which case the read should be retried.
+func (conn *Conn) read(buffer []byte, deadline time.Time, changed chan struct{}) (int, net.Addr, error, bool) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case packet := <-conn.packets:
+		return copy(buffer, packet.Buffer), packet.From, nil, true
+	case <-conn.closed:
+		return 0, nil, conn.error("read", net.ErrClosed), true
+	case <-expired:
+		return 0, nil, conn.error("read", os.ErrDeadlineExceeded), true
+	case <-changed:
+		return 0, nil, nil, false
+	}
+}
+
+// WriteTo writes a packet to the given address of the network.
+// Writing to an address no connection listens on does not fail, but the packet is dropped.
+func (conn *Conn) WriteTo(buffer []byte, addr net.Addr) (int, error) {
+	select {
+	case <-conn.closed:
+		return 0, conn.error("write", net.ErrClosed)
+	default:
+	}
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		var err error
+		if to, err = net.ResolveUDPAddr("udp", addr.String()); err != nil {
+			return 0, conn.error("write", err)
+		}
+	}
+	if v4 := to.IP.To4(); v4 != nil {
+		to = &net.UDPAddr{IP: v4, Port: to.Port}
+	}
+	conn.network.send(Packet{From: conn.addr, To: to, Buffer: append([]byte{}, buffer...)})
+	return len(buffer), nil
+}
+
+// Close closes the connection, and removes it from the network.
+// Any blocked ReadFrom call is unblocked and returns an error.
+func (conn *Conn) Close() error {
+	err := conn.error("close", net.ErrClosed)
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+		conn.network.remove(conn)
+		err = nil
+	})
+	return err
+}
+
+// LocalAddr returns the address the connection listens on.
+func (conn *Conn) LocalAddr() net.Addr {
+	return conn.addr
+}
+
+// SetDeadline sets the read deadline of the connection.
+// Writes never block, so that the write deadline is ignored.
+func (conn *Conn) SetDeadline(t time.Time) error {
+	return conn.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of ReadFrom calls, including calls currently blocked.
+// A zero time disables the deadline.
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.readDeadline = t
+	close(conn.deadlineChanged)
+	conn.deadlineChanged = make(chan struct{})
+	conn.mutex.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes never block.
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// receive queues a packet delivered to the connection to be read.
+// The packet is dropped if the connection is closed, or if its backlog is full.
+func (conn *Conn) receive(packet Packet) bool {
+	select {
+	case <-conn.closed:
+		return false
+	default:
+	}
+	select {
+	case conn.packets <- packet:
+		return true
+	default:
+		return false
+	}
+}
+
+// error returns an error of an operation on the connection.
+func (conn *Conn) error(op string, err error) error {
+	return &net.OpError{Op: op, Net: "loopback", Addr: conn.addr, Err: err}
+}
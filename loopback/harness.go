@@ -0,0 +1,210 @@
+package loopback
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/impair"
+	"github.com/irmine/goraklib/server"
+)
+
+// A Harness drives managers over an in-memory network deterministically.
+// Managers of a harness are never run: the harness delivers the packets written to them itself,
+// and ticks them every server.TickInterval of its virtual clock. All managers of a harness use its clock,
+// and handle their datagrams synchronously, so that the same steps always have the same outcome.
+// A harness must only be used from a single goroutine.
+type Harness struct {
+	// Clock is the virtual clock of all managers of the harness.
+	Clock *server.VirtualClock
+	// Network is the network the managers of the harness listen on.
+	// The network holds all packets, which are delivered by the harness every step.
+	Network *Network
+	// Filter gets called for every packet before it is delivered.
+	// Packets for which it returns false are dropped, so that loss can be simulated.
+	// All packets are delivered if the filter is nil.
+	Filter func(packet Packet) bool
+
+	managers []*server.Manager
+	conns    map[*Conn]*server.Manager
+	impaired []*impair.Conn
+}
+
+// NewHarness returns a new harness with a new network, and a virtual clock starting at the Unix epoch.
+func NewHarness() *Harness {
+	network := NewNetwork()
+	network.Hold = true
+	return &Harness{Clock: server.NewVirtualClock(time.Unix(0, 0)), Network: network, conns: make(map[*Conn]*server.Manager)}
+}
+
+// NewManager returns a new manager of the harness, listening on the given address of its network.
+// The manager logs nothing, and uses the virtual clock of the harness.
+// The manager must not be run; the harness ticks it, and delivers packets to it.
+func (harness *Harness) NewManager(address string) (*server.Manager, error) {
+	conn, err := harness.Network.ListenPacket(address)
+	if err != nil {
+		return nil, err
+	}
+	manager := server.NewManager()
+	manager.Server.PacketConn = conn
+	manager.Clock = harness.Clock
+	manager.HandleSynchronously = true
+	manager.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if manager.RateLimiter != nil {
+		manager.RateLimiter.Clock = harness.Clock
+	}
+	harness.managers = append(harness.managers, manager)
+	harness.conns[conn] = manager
+	return manager, nil
+}
+
+// Impair impairs all packets written by a manager of the harness using the given config.
+// Delays of the impairment are measured using the virtual clock of the harness,
+// and delayed packets are delivered during the step in which they are due.
+func (harness *Harness) Impair(manager *server.Manager, config impair.Config) *impair.Conn {
+	conn := impair.NewVirtualConn(manager.Server.PacketConn, config, harness.Clock)
+	manager.Server.PacketConn = conn
+	harness.impaired = append(harness.impaired, conn)
+	return conn
+}
+
+// Deliver delivers all packets held by the network, including packets written while delivering,
+// and returns the amount of packets delivered. Packets written to a manager of the harness are handled
+// by that manager immediately. Packets written to any other connection are delivered to it to be read.
+func (harness *Harness) Deliver() int {
+	delivered := 0
+	for {
+		packet, ok := harness.Network.Next()
+		if !ok {
+			return delivered
+		}
+		if harness.Filter != nil && !harness.Filter(packet) {
+			continue
+		}
+		delivered++
+		if manager, ok := harness.manager(packet.To); ok {
+			manager.HandlePacket(packet.Buffer, packet.From)
+			continue
+		}
+		harness.Network.Deliver(packet)
+	}
+}
+
+// Step delivers all packets held, advances the clock by a single tick and ticks all managers,
+// after which the packets written by the managers while ticking are delivered,
+// along with the delayed packets of impaired managers that became due.
+func (harness *Harness) Step() {
+	harness.Deliver()
+	harness.Clock.Advance(server.TickInterval)
+	for _, conn := range harness.impaired {
+		conn.Release()
+	}
+	for _, manager := range harness.managers {
+		if manager.Server.HasStarted() {
+			manager.Tick()
+		}
+	}
+	harness.Deliver()
+}
+
+// Run steps the harness until the clock has advanced by the given duration.
+func (harness *Harness) Run(duration time.Duration) {
+	for end := harness.Clock.Now().Add(duration); harness.Clock.Now().Before(end); {
+		harness.Step()
+	}
+}
+
+// RunUntil steps the harness until the condition is met, or until the clock has advanced by the timeout.
+// RunUntil returns true if the condition was met.
+func (harness *Harness) RunUntil(condition func() bool, timeout time.Duration) bool {
+	for end := harness.Clock.Now().Add(timeout); !condition(); harness.Step() {
+		if !harness.Clock.Now().Before(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dial dials a server listening on the given address of the network, using a manager of the harness.
+// The full handshake is executed, and the session returned is fully connected.
+// Dial returns the same errors as client.Dial, once the clock of the harness advanced by client.DefaultTimeout.
+func (harness *Harness) Dial(manager *server.Manager, address string) (*server.Session, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	deadline := harness.Clock.Now().Add(client.DefaultTimeout)
+	handshake := client.NewHandshake(addr, client.ProtocolVersion, manager.ServerId)
+
+	// Replies of the server are raw packets to the manager, as it has no session yet.
+	rawPacketFunction := manager.RawPacketFunction
+	defer func() {
+		manager.RawPacketFunction = rawPacketFunction
+	}()
+	var handshakeErr error
+	progressed := false
+	manager.RawPacketFunction = func(packet []byte, sender *net.UDPAddr) {
+		if !sender.IP.Equal(addr.IP) || sender.Port != addr.Port {
+			rawPacketFunction(packet, sender)
+			return
+		}
+		ok, err := handshake.HandleReply(append([]byte{}, packet...))
+		progressed = progressed || ok
+		if err != nil && handshakeErr == nil {
+			handshakeErr = err
+		}
+	}
+	for !handshake.Done() {
+		if _, err := manager.Server.Write(handshake.Request(), addr); err != nil {
+			return nil, err
+		}
+		progressed = false
+		harness.RunUntil(func() bool {
+			return progressed || handshakeErr != nil || !harness.Clock.Now().Before(deadline)
+		}, client.HandshakeRetryInterval)
+		if handshakeErr != nil {
+			return nil, handshakeErr
+		}
+		if progressed {
+			continue
+		}
+		if !harness.Clock.Now().Before(deadline) {
+			return nil, client.TimedOut
+		}
+		if err := handshake.Retry(); err != nil {
+			return nil, err
+		}
+	}
+
+	connectFunction := manager.ConnectFunction
+	defer func() {
+		manager.ConnectFunction = connectFunction
+	}()
+	var connected *server.Session
+	manager.ConnectFunction = func(session *server.Session) {
+		connected = session
+		connectFunction(session)
+	}
+	session := handshake.Connect(manager)
+	if !harness.RunUntil(func() bool {
+		return connected == session
+	}, deadline.Sub(harness.Clock.Now())) {
+		// The session is closed right away, so that it can not connect once the dial timed out.
+		session.Abort(server.DisconnectReasonTimeout)
+		manager.Tick()
+		return nil, client.TimedOut
+	}
+	return session, nil
+}
+
+// manager returns the manager of the harness listening on the given address.
+func (harness *Harness) manager(addr *net.UDPAddr) (*server.Manager, bool) {
+	conn, ok := harness.Network.Conn(addr)
+	if !ok {
+		return nil, false
+	}
+	manager, ok := harness.conns[conn]
+	return manager, ok
+}
@@ -0,0 +1,157 @@
+// Package loopback implements an in-memory packet network,
+// and a harness that drives managers over it deterministically using a virtual clock.
+package loopback
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// firstPort is the first port assigned to connections listening on port 0.
+const firstPort = 40000
+
+// ErrAddressInUse is returned by ListenPacket if a connection is already listening on the address.
+var ErrAddressInUse = errors.New("address already in use")
+
+// A Packet is a packet written to the network.
+type Packet struct {
+	// From is the address of the connection that wrote the packet.
+	From *net.UDPAddr
+	// To is the address the packet was written to.
+	To *net.UDPAddr
+	// Buffer is the buffer of the packet.
+	Buffer []byte
+}
+
+// A Network is an in-memory packet network. Connections listen on the network using ListenPacket,
+// and packets written to the address of a connection can be read from that connection.
+// Packets written to an address no connection listens on are dropped, like they would be over UDP.
+// A network is safe for concurrent use.
+type Network struct {
+	// Hold makes the network hold all packets written, until they are taken using Next or delivered using Flush.
+	// Packets are delivered immediately if Hold is false.
+	Hold bool
+
+	mutex    sync.Mutex
+	conns    map[string]*Conn
+	held     []Packet
+	nextPort int
+}
+
+// NewNetwork returns a new network that delivers packets immediately.
+func NewNetwork() *Network {
+	return &Network{conns: make(map[string]*Conn), nextPort: firstPort}
+}
+
+// ListenPacket returns a new connection listening on the given address of the network.
+// The address should be of the form "host:port", and the host must be an IP address.
+// A free port is assigned to the connection if the port is 0.
+func (network *Network) ListenPacket(address string) (*Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "invalid IP address", Addr: host}
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid port", Addr: address}
+	}
+
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	addr := &net.UDPAddr{IP: ip, Port: port}
+	if port == 0 {
+		for {
+			addr.Port = network.nextPort
+			network.nextPort++
+			if _, ok := network.conns[addr.String()]; !ok {
+				break
+			}
+		}
+	} else if _, ok := network.conns[addr.String()]; ok {
+		return nil, ErrAddressInUse
+	}
+	conn := newConn(network, addr)
+	network.conns[addr.String()] = conn
+	return conn, nil
+}
+
+// Conn returns the connection listening on the given address, and a bool indicating if one was found.
+func (network *Network) Conn(addr *net.UDPAddr) (*Conn, bool) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	conn, ok := network.conns[addr.String()]
+	return conn, ok
+}
+
+// Held returns the amount of packets held by the network.
+func (network *Network) Held() int {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	return len(network.held)
+}
+
+// Next takes the packet held the longest from the network, and returns it.
+// The packet is not delivered; it may be delivered using Deliver, or be dropped.
+// Next returns false if no packets are held.
+func (network *Network) Next() (Packet, bool) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	if len(network.held) == 0 {
+		return Packet{}, false
+	}
+	packet := network.held[0]
+	network.held = network.held[1:]
+	return packet, true
+}
+
+// Deliver delivers a packet to the connection listening on its destination address,
+// regardless of whether the network holds packets. Deliver returns false if the packet was dropped,
+// because no connection listens on the address, or because the connection has too many packets unread.
+func (network *Network) Deliver(packet Packet) bool {
+	conn, ok := network.Conn(packet.To)
+	if !ok {
+		return false
+	}
+	return conn.receive(packet)
+}
+
+// Flush delivers all packets held by the network, in the order they were written.
+func (network *Network) Flush() {
+	for {
+		packet, ok := network.Next()
+		if !ok {
+			return
+		}
+		network.Deliver(packet)
+	}
+}
+
+// send sends a packet written by a connection, either holding or delivering it.
+func (network *Network) send(packet Packet) {
+	network.mutex.Lock()
+	if network.Hold {
+		network.held = append(network.held, packet)
+		network.mutex.Unlock()
+		return
+	}
+	network.mutex.Unlock()
+	network.Deliver(packet)
+}
+
+// remove removes a closed connection from the network, so that its address can be listened on again.
+func (network *Network) remove(conn *Conn) {
+	network.mutex.Lock()
+	if network.conns[conn.addr.String()] == conn {
+		delete(network.conns, conn.addr.String())
+	}
+	network.mutex.Unlock()
+}
@@ -6,6 +6,10 @@ import (
 	"github.com/irmine/binutils"
 )
 
+// maximumRangeSpan is the maximum difference between the start and end of a range of an ACK or NAK.
+// Longer ranges are truncated when decoded, so that they are split into several ranges when encoded.
+const maximumRangeSpan = 512
+
 type AcknowledgementPacket struct {
 	*Packet
 	Packets []uint32
@@ -58,21 +62,23 @@ func (packet *AcknowledgementPacket) Encode() {
 		currentPacket := packet.Packets[pointer]
 		difference := currentPacket - lastPacket
 
-		if difference == 1 {
+		if difference == 0 {
+			pointer++
+			continue
+		}
+		if difference == 1 && lastPacket-firstPacket < maximumRangeSpan {
 			lastPacket = currentPacket
 		} else {
 			if firstPacket == lastPacket {
 				stream.PutByte(01)
 				stream.PutLittleTriad(lastPacket)
-				currentPacket = lastPacket
 			} else {
 				stream.PutByte(0)
 				stream.PutLittleTriad(firstPacket)
 				stream.PutLittleTriad(lastPacket)
-
-				lastPacket = currentPacket
-				firstPacket = lastPacket
 			}
+			firstPacket = currentPacket
+			lastPacket = currentPacket
 			intervalCount++
 		}
 
@@ -92,30 +98,46 @@ func (packet *AcknowledgementPacket) Encode() {
 	packet.PutBytes(stream.Buffer)
 }
 
-func (packet *AcknowledgementPacket) Decode() {
+func (packet *AcknowledgementPacket) Decode() error {
 	packet.DecodeStep()
 
 	packet.Packets = []uint32{}
+	if err := packet.require(2); err != nil {
+		return err
+	}
 	var packetCount = packet.GetShort()
 	var count = 0
 
-	for i := int16(0); i < packetCount && !packet.Feof() && count < 4096; i++ {
+	for i := int16(0); i < packetCount && count < 4096; i++ {
+		if err := packet.require(1); err != nil {
+			return err
+		}
 		if packet.GetByte() == 0 {
+			if err := packet.require(6); err != nil {
+				return err
+			}
 			var start = packet.GetLittleTriad()
 			var end = packet.GetLittleTriad()
 
-			if (end - start) > 512 {
-				end = start + 512
+			if end < start {
+				return ErrInvalidRange
+			}
+			if (end - start) > maximumRangeSpan {
+				end = start + maximumRangeSpan
 			}
 
-			for pack := start; pack < end; pack++ {
+			for pack := start; pack <= end; pack++ {
 				packet.Packets = append(packet.Packets, pack)
 				count++
 			}
 
 		} else {
+			if err := packet.require(3); err != nil {
+				return err
+			}
 			packet.Packets = append(packet.Packets, packet.GetLittleTriad())
 			count++
 		}
 	}
+	return nil
 }
@@ -16,7 +16,11 @@ func (ping *ConnectedPing) Encode() {
 	ping.PutLong(ping.PingSendTime)
 }
 
-func (ping *ConnectedPing) Decode() {
+func (ping *ConnectedPing) Decode() error {
 	ping.DecodeStep()
+	if err := ping.require(8); err != nil {
+		return err
+	}
 	ping.PingSendTime = ping.GetLong()
+	return nil
 }
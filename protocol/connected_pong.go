@@ -18,8 +18,12 @@ func (pong *ConnectedPong) Encode() {
 	pong.PutLong(pong.PongSendTime)
 }
 
-func (pong *ConnectedPong) Decode() {
+func (pong *ConnectedPong) Decode() error {
 	pong.DecodeStep()
+	if err := pong.require(16); err != nil {
+		return err
+	}
 	pong.PingSendTime = pong.GetLong()
 	pong.PongSendTime = pong.GetLong()
+	return nil
 }
@@ -19,7 +19,7 @@ func NewConnectionAccept() *ConnectionAccept {
 
 func (request *ConnectionAccept) Encode() {
 	request.EncodeId()
-	request.PutAddress(request.ClientAddress, request.ClientPort, 4)
+	request.PutAddress(request.ClientAddress, request.ClientPort, AddressVersion(request.ClientAddress))
 	request.PutShort(0)
 
 	for i := 0; i < 20; i++ {
@@ -34,17 +34,37 @@ func (request *ConnectionAccept) Encode() {
 	request.PutUnsignedLong(request.PongSendTime)
 }
 
-func (request *ConnectionAccept) Decode() {
+func (request *ConnectionAccept) Decode() error {
 	request.DecodeStep()
-	request.ClientAddress, request.ClientPort, _ = request.GetAddress()
+	var err error
+	if request.ClientAddress, request.ClientPort, _, err = request.GetAddress(); err != nil {
+		return err
+	}
+	if err := request.require(2); err != nil {
+		return err
+	}
+	request.GetShort()
 
-	for i := 0; i < 20; i++ {
-		address, port, version := request.GetAddress()
+	request.SystemAddresses = []string{}
+	request.SystemPorts = []uint16{}
+	request.SystemIdVersions = []byte{}
+
+	// Implementations differ in the amount of system addresses sent,
+	// so all addresses up to the two trailing timestamps are read.
+	for len(request.Buffer) - request.Offset > 16 {
+		address, port, version, err := request.GetAddress()
+		if err != nil {
+			return err
+		}
 		request.SystemAddresses = append(request.SystemAddresses, address)
 		request.SystemPorts = append(request.SystemPorts, port)
 		request.SystemIdVersions = append(request.SystemIdVersions, version)
 	}
 
+	if err := request.require(16); err != nil {
+		return err
+	}
 	request.PingSendTime = request.GetUnsignedLong()
 	request.PongSendTime = request.GetUnsignedLong()
+	return nil
 }
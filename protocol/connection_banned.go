@@ -0,0 +1,30 @@
+package protocol
+
+type ConnectionBanned struct {
+	*UnconnectedMessage
+	ServerId int64
+}
+
+func NewConnectionBanned() *ConnectionBanned {
+	return &ConnectionBanned{NewUnconnectedMessage(NewPacket(
+		IdConnectionBanned,
+	)), 0}
+}
+
+func (response *ConnectionBanned) Encode() {
+	response.EncodeId()
+	response.PutMagic()
+	response.PutLong(response.ServerId)
+}
+
+func (response *ConnectionBanned) Decode() error {
+	response.DecodeStep()
+	if err := response.ReadMagic(); err != nil {
+		return err
+	}
+	if err := response.require(8); err != nil {
+		return err
+	}
+	response.ServerId = response.GetLong()
+	return nil
+}
@@ -17,10 +17,19 @@ func (request *ConnectionRequest) Encode() {
 	request.EncodeId()
 	request.PutUnsignedLong(request.ClientId)
 	request.PutUnsignedLong(request.PingSendTime)
+	request.PutByte(request.Security)
 }
 
-func (request *ConnectionRequest) Decode() {
+func (request *ConnectionRequest) Decode() error {
 	request.DecodeStep()
+	if err := request.require(16); err != nil {
+		return err
+	}
 	request.ClientId = request.GetUnsignedLong()
 	request.PingSendTime = request.GetUnsignedLong()
+	// The security byte is left out by some clients.
+	if request.require(1) == nil {
+		request.Security = request.GetByte()
+	}
+	return nil
 }
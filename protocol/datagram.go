@@ -54,7 +54,10 @@ func (datagram *Datagram) Encode() {
 	}
 }
 
-func (datagram *Datagram) Decode() {
+func (datagram *Datagram) Decode() error {
+	if err := datagram.require(4); err != nil {
+		return err
+	}
 	var flags = datagram.GetByte()
 	datagram.PacketPair = (flags & BitFlagPacketPair) != 0
 	datagram.ContinuousSend = (flags & BitFlagContinuousSend) != 0
@@ -62,13 +65,26 @@ func (datagram *Datagram) Decode() {
 
 	datagram.SequenceNumber = datagram.GetLittleTriad()
 
-	for !datagram.Feof() {
-		packet := NewEncapsulatedPacket()
-		packet, err := packet.GetFromBinary(datagram)
-		if err == nil {
-			var packets = append(*datagram.packets, packet)
-			datagram.packets = &packets
+	for datagram.Offset < len(datagram.Buffer) {
+		packet, err := NewEncapsulatedPacket().GetFromBinary(datagram)
+		if err != nil {
+			return err
 		}
+		var packets = append(*datagram.packets, packet)
+		datagram.packets = &packets
+	}
+	return nil
+}
+
+// SetSequenceNumber changes the sequence number of the datagram.
+// The sequence number in the buffer is changed too if the datagram has already been encoded,
+// so that the datagram does not need to be encoded again.
+func (datagram *Datagram) SetSequenceNumber(sequenceNumber uint32) {
+	datagram.SequenceNumber = sequenceNumber
+	if len(datagram.Buffer) >= 4 {
+		datagram.Buffer[1] = byte(sequenceNumber)
+		datagram.Buffer[2] = byte(sequenceNumber >> 8)
+		datagram.Buffer[3] = byte(sequenceNumber >> 16)
 	}
 }
 
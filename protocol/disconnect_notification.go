@@ -0,0 +1,20 @@
+package protocol
+
+type DisconnectNotification struct {
+	*Packet
+}
+
+func NewDisconnectNotification() *DisconnectNotification {
+	return &DisconnectNotification{NewPacket(
+		IdDisconnectNotification,
+	)}
+}
+
+func (notification *DisconnectNotification) Encode() {
+	notification.EncodeId()
+}
+
+func (notification *DisconnectNotification) Decode() error {
+	notification.DecodeStep()
+	return notification.require(0)
+}
@@ -1,9 +1,5 @@
 package protocol
 
-import (
-	"errors"
-)
-
 const (
 	ReliabilityUnreliable byte = iota
 	ReliabilityUnreliableSequenced
@@ -36,40 +32,60 @@ func NewEncapsulatedPacket() *EncapsulatedPacket {
 	return &packet
 }
 
+// GetFromBinary decodes an encapsulated packet from the datagram, starting at the current offset of the datagram.
+// An error is returned if the datagram ends before the encapsulated packet does,
+// or if the encapsulated packet is malformed.
 func (packet *EncapsulatedPacket) GetFromBinary(stream *Datagram) (*EncapsulatedPacket, error) {
+	if err := stream.require(3); err != nil {
+		return packet, err
+	}
 	var flags = stream.GetByte()
 	packet.Reliability = (flags & 224) >> 5
 	packet.HasSplit = (flags & SplitFlag) != 0
 
-	if stream.Feof() {
-		return packet, errors.New("no bytes left to read")
-	}
-
-	packet.Length = uint(stream.GetShort() / 8)
+	packet.Length = uint(stream.GetUnsignedShort() / 8)
 
 	if packet.Length == 0 {
-		return packet, errors.New("null encapsulated packet")
+		return packet, ErrEmptyPacket
 	}
 
 	if packet.IsReliable() {
+		if err := stream.require(3); err != nil {
+			return packet, err
+		}
 		packet.MessageIndex = stream.GetLittleTriad()
 	}
 
 	if packet.IsSequenced() {
-		packet.SequenceIndex = packet.GetLittleTriad()
+		if err := stream.require(3); err != nil {
+			return packet, err
+		}
+		packet.SequenceIndex = stream.GetLittleTriad()
 	}
 
 	if packet.IsSequencedOrOrdered() {
+		if err := stream.require(4); err != nil {
+			return packet, err
+		}
 		packet.OrderIndex = stream.GetLittleTriad()
 		packet.OrderChannel = stream.GetByte()
 	}
 
 	if packet.HasSplit {
-		packet.SplitCount = uint(stream.GetInt())
+		if err := stream.require(10); err != nil {
+			return packet, err
+		}
+		packet.SplitCount = uint(uint32(stream.GetInt()))
 		packet.SplitId = stream.GetShort()
-		packet.SplitIndex = uint(stream.GetInt())
+		packet.SplitIndex = uint(uint32(stream.GetInt()))
+		if packet.SplitIndex >= packet.SplitCount {
+			return packet, ErrInvalidSplit
+		}
 	}
 
+	if err := stream.require(int(packet.Length)); err != nil {
+		return packet, err
+	}
 	packet.SetBuffer(stream.Get(int(packet.Length)))
 
 	return packet, nil
@@ -166,6 +182,9 @@ func (packet *EncapsulatedPacket) GetLength() int {
 		length += 3
 	}
 	if packet.IsSequenced() {
+		length += 3
+	}
+	if packet.IsSequencedOrOrdered() {
 		length += 4
 	}
 	if packet.HasSplit {
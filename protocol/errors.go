@@ -0,0 +1,20 @@
+package protocol
+
+import (
+	"errors"
+)
+
+var (
+	// ErrShortBuffer is returned when decoding a packet that is shorter than its fields require.
+	ErrShortBuffer = errors.New("packet buffer too short")
+	// ErrInvalidMagic is returned when decoding an unconnected message without the offline message magic.
+	ErrInvalidMagic = errors.New("invalid offline message magic")
+	// ErrInvalidAddress is returned when decoding an address of an IP version other than 4 or 6.
+	ErrInvalidAddress = errors.New("invalid address version")
+	// ErrEmptyPacket is returned when decoding an encapsulated packet without any payload.
+	ErrEmptyPacket = errors.New("empty encapsulated packet")
+	// ErrInvalidSplit is returned when decoding a split encapsulated packet with a split index out of its split count.
+	ErrInvalidSplit = errors.New("invalid split index")
+	// ErrInvalidRange is returned when decoding an ACK or NAK range of which the end comes before the start.
+	ErrInvalidRange = errors.New("invalid acknowledgement range")
+)
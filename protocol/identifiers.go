@@ -21,6 +21,10 @@ const (
 	IdConnectionAccept  = 0x10
 
 	IdNewIncomingConnection = 0x13
+	IdNoFreeIncomingConnections = 0x14
 
 	IdDisconnectNotification = 0x15
+	IdConnectionBanned = 0x17
+
+	IdIncompatibleProtocolVersion = 0x19
 )
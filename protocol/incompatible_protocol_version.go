@@ -0,0 +1,36 @@
+package protocol
+
+type IncompatibleProtocolVersion struct {
+	*UnconnectedMessage
+	Protocol byte
+	ServerId int64
+}
+
+func NewIncompatibleProtocolVersion() *IncompatibleProtocolVersion {
+	return &IncompatibleProtocolVersion{NewUnconnectedMessage(NewPacket(
+		IdIncompatibleProtocolVersion,
+	)), 0, 0}
+}
+
+func (response *IncompatibleProtocolVersion) Encode() {
+	response.EncodeId()
+	response.PutByte(response.Protocol)
+	response.PutMagic()
+	response.PutLong(response.ServerId)
+}
+
+func (response *IncompatibleProtocolVersion) Decode() error {
+	response.DecodeStep()
+	if err := response.require(1); err != nil {
+		return err
+	}
+	response.Protocol = response.GetByte()
+	if err := response.ReadMagic(); err != nil {
+		return err
+	}
+	if err := response.require(8); err != nil {
+		return err
+	}
+	response.ServerId = response.GetLong()
+	return nil
+}
@@ -19,7 +19,7 @@ func NewNewIncomingConnection() *NewIncomingConnection {
 
 func (request *NewIncomingConnection) Encode() {
 	request.EncodeId()
-	request.PutAddress(request.ServerAddress, request.ServerPort, 4)
+	request.PutAddress(request.ServerAddress, request.ServerPort, AddressVersion(request.ServerAddress))
 
 	for i := 0; i < 20; i++ {
 		if i < len(request.SystemAddresses) {
@@ -33,16 +33,31 @@ func (request *NewIncomingConnection) Encode() {
 	request.PutUnsignedLong(request.PongSendTime)
 }
 
-func (request *NewIncomingConnection) Decode() {
+func (request *NewIncomingConnection) Decode() error {
 	request.DecodeStep()
-	request.ServerAddress, request.ServerPort, _ = request.GetAddress()
-	for i := 0; i < 20; i++ {
-		address, port, version := request.GetAddress()
+	var err error
+	if request.ServerAddress, request.ServerPort, _, err = request.GetAddress(); err != nil {
+		return err
+	}
+
+	request.SystemAddresses = []string{}
+	request.SystemPorts = []uint16{}
+	request.SystemIdVersions = []byte{}
+
+	for len(request.Buffer) - request.Offset > 16 {
+		address, port, version, err := request.GetAddress()
+		if err != nil {
+			return err
+		}
 		request.SystemAddresses = append(request.SystemAddresses, address)
 		request.SystemPorts = append(request.SystemPorts, port)
 		request.SystemIdVersions = append(request.SystemIdVersions, version)
 	}
 
+	if err := request.require(16); err != nil {
+		return err
+	}
 	request.PingSendTime = request.GetUnsignedLong()
 	request.PongSendTime = request.GetUnsignedLong()
+	return nil
 }
@@ -0,0 +1,30 @@
+package protocol
+
+type NoFreeIncomingConnections struct {
+	*UnconnectedMessage
+	ServerId int64
+}
+
+func NewNoFreeIncomingConnections() *NoFreeIncomingConnections {
+	return &NoFreeIncomingConnections{NewUnconnectedMessage(NewPacket(
+		IdNoFreeIncomingConnections,
+	)), 0}
+}
+
+func (response *NoFreeIncomingConnections) Encode() {
+	response.EncodeId()
+	response.PutMagic()
+	response.PutLong(response.ServerId)
+}
+
+func (response *NoFreeIncomingConnections) Decode() error {
+	response.DecodeStep()
+	if err := response.ReadMagic(); err != nil {
+		return err
+	}
+	if err := response.require(8); err != nil {
+		return err
+	}
+	response.ServerId = response.GetLong()
+	return nil
+}
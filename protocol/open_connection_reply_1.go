@@ -5,12 +5,13 @@ type OpenConnectionReply1 struct {
 	ServerId int64
 	MtuSize  int16
 	Security bool
+	Cookie   uint32
 }
 
 func NewOpenConnectionReply1() *OpenConnectionReply1 {
 	return &OpenConnectionReply1{NewUnconnectedMessage(NewPacket(
 		IdOpenConnectionReply1,
-	)), 0, 0, false}
+	)), 0, 0, false, 0}
 }
 
 func (response *OpenConnectionReply1) Encode() {
@@ -18,13 +19,31 @@ func (response *OpenConnectionReply1) Encode() {
 	response.PutMagic()
 	response.PutLong(response.ServerId)
 	response.PutBool(response.Security)
+	if response.Security {
+		response.PutInt(int32(response.Cookie))
+	}
 	response.PutShort(response.MtuSize)
 }
 
-func (response *OpenConnectionReply1) Decode() {
+func (response *OpenConnectionReply1) Decode() error {
 	response.DecodeStep()
-	response.ReadMagic()
+	if err := response.ReadMagic(); err != nil {
+		return err
+	}
+	if err := response.require(9); err != nil {
+		return err
+	}
 	response.ServerId = response.GetLong()
 	response.Security = response.GetBool()
+	if response.Security {
+		if err := response.require(4); err != nil {
+			return err
+		}
+		response.Cookie = uint32(response.GetInt())
+	}
+	if err := response.require(2); err != nil {
+		return err
+	}
 	response.MtuSize = response.GetShort()
+	return nil
 }
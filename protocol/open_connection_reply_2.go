@@ -19,16 +19,28 @@ func (response *OpenConnectionReply2) Encode() {
 	response.EncodeId()
 	response.PutMagic()
 	response.PutLong(response.ServerId)
-	response.PutAddress(response.ClientAddress, response.ClientPort, 4)
+	response.PutAddress(response.ClientAddress, response.ClientPort, AddressVersion(response.ClientAddress))
 	response.PutShort(response.MtuSize)
 	response.PutBool(response.UseEncryption)
 }
 
-func (response *OpenConnectionReply2) Decode() {
+func (response *OpenConnectionReply2) Decode() error {
 	response.DecodeStep()
-	response.ReadMagic()
+	if err := response.ReadMagic(); err != nil {
+		return err
+	}
+	if err := response.require(8); err != nil {
+		return err
+	}
 	response.ServerId = response.GetLong()
-	response.ClientAddress, response.ClientPort, _ = response.GetAddress()
+	var err error
+	if response.ClientAddress, response.ClientPort, _, err = response.GetAddress(); err != nil {
+		return err
+	}
+	if err := response.require(3); err != nil {
+		return err
+	}
 	response.MtuSize = response.GetShort()
 	response.UseEncryption = response.GetBool()
+	return nil
 }
@@ -17,13 +17,22 @@ func (request *OpenConnectionRequest1) Encode() {
 	request.PutMagic()
 	request.PutByte(request.Protocol)
 
-	bytes := make([]byte, request.MtuSize+28)
-	request.PutBytes(bytes)
+	// The request is padded so that the full UDP datagram matches the MTU size.
+	var padding = int(request.MtuSize) - 28 - len(request.Buffer)
+	if padding > 0 {
+		request.PutBytes(make([]byte, padding))
+	}
 }
 
-func (request *OpenConnectionRequest1) Decode() {
+func (request *OpenConnectionRequest1) Decode() error {
 	request.DecodeStep()
-	request.ReadMagic()
+	if err := request.ReadMagic(); err != nil {
+		return err
+	}
+	if err := request.require(1); err != nil {
+		return err
+	}
 	request.Protocol = request.GetByte()
 	request.MtuSize = int16(len(request.Buffer)) + 28 // Account for UDP and IP headers.
+	return nil
 }
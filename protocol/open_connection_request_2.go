@@ -2,6 +2,10 @@ package protocol
 
 type OpenConnectionRequest2 struct {
 	*UnconnectedMessage
+	// Security indicates if the request holds a security cookie.
+	// It must be set before decoding, as the request itself does not indicate it.
+	Security      bool
+	Cookie        uint32
 	ServerAddress string
 	ServerPort    uint16
 	MtuSize       int16
@@ -11,23 +15,48 @@ type OpenConnectionRequest2 struct {
 func NewOpenConnectionRequest2() *OpenConnectionRequest2 {
 	return &OpenConnectionRequest2{NewUnconnectedMessage(NewPacket(
 		IdOpenConnectionRequest2,
-	)), "", 0, 0, 0}
+	)), false, 0, "", 0, 0, 0}
 }
 
 func (request *OpenConnectionRequest2) Encode() {
 	request.EncodeId()
 	request.PutMagic()
-	request.PutAddress(request.ServerAddress, request.ServerPort, 4)
+	if request.Security {
+		request.PutInt(int32(request.Cookie))
+		request.PutBool(false) // The client did not write a challenge.
+	}
+	request.PutAddress(request.ServerAddress, request.ServerPort, AddressVersion(request.ServerAddress))
 	request.PutShort(request.MtuSize)
 	request.PutLong(request.ClientId)
 }
 
-func (request *OpenConnectionRequest2) Decode() {
+func (request *OpenConnectionRequest2) Decode() error {
 	request.DecodeStep()
-	request.ReadMagic()
-	var address, port, _ = request.GetAddress()
+	if err := request.ReadMagic(); err != nil {
+		return err
+	}
+	if request.Security {
+		if err := request.require(5); err != nil {
+			return err
+		}
+		request.Cookie = uint32(request.GetInt())
+		if request.GetBool() {
+			if err := request.require(64); err != nil {
+				return err
+			}
+			request.Get(64) // The challenge of the client, which is not used.
+		}
+	}
+	var address, port, _, err = request.GetAddress()
+	if err != nil {
+		return err
+	}
 	request.ServerAddress = address
 	request.ServerPort = port
+	if err := request.require(10); err != nil {
+		return err
+	}
 	request.MtuSize = request.GetShort()
 	request.ClientId = request.GetLong()
+	return nil
 }
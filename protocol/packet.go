@@ -1,13 +1,17 @@
 package protocol
 
 import (
+	"net"
 	"strconv"
 	"strings"
 
-
 	"github.com/irmine/binutils"
 )
 
+// AddressFamilyIPv6 is the sockaddr_in6 family written for IPv6 addresses.
+// This is the value of AF_INET6 on Windows, which is what RakNet implementations expect.
+const AddressFamilyIPv6 = 23
+
 type Packet struct {
 	packetId int
 	*binutils.Stream
@@ -23,7 +27,7 @@ type IPacket interface {
 	GetBuffer() []byte
 	GetId() int
 	Encode()
-	Decode()
+	Decode() error
 	HasMagic() bool
 }
 
@@ -44,6 +48,15 @@ func (packet *Packet) DecodeStep() {
 	packet.Offset = 1
 }
 
+// require checks if at least n bytes are left to read,
+// and returns ErrShortBuffer if there are not.
+func (packet *Packet) require(n int) error {
+	if len(packet.Buffer)-packet.Offset < n {
+		return ErrShortBuffer
+	}
+	return nil
+}
+
 func (packet *Packet) EncodeId() {
 	packet.Buffer = []byte{}
 	var newBuffer = append(packet.Buffer, byte(packet.packetId))
@@ -54,11 +67,18 @@ func (packet *Packet) ResetBase() {
 	packet.ResetStream()
 }
 
-func (packet *Packet) GetAddress() (address string, port uint16, ipVersion byte) {
+func (packet *Packet) GetAddress() (address string, port uint16, ipVersion byte, err error) {
+	if err = packet.require(1); err != nil {
+		return
+	}
 	ipVersion = packet.GetByte()
 	switch ipVersion {
 	default:
+		err = ErrInvalidAddress
 	case 4:
+		if err = packet.require(6); err != nil {
+			return
+		}
 		var parts = []byte{(-packet.GetByte() - 1) & 0xff, (-packet.GetByte() - 1) & 0xff, (-packet.GetByte() - 1) & 0xff, (-packet.GetByte() - 1) & 0xff}
 		var stringArr []string
 		for _, part := range parts {
@@ -68,11 +88,18 @@ func (packet *Packet) GetAddress() (address string, port uint16, ipVersion byte)
 		address = strings.Join(stringArr, ".")
 		port = packet.GetUnsignedShort()
 	case 6:
+		// IPv6 addresses are written as a sockaddr_in6 struct:
+		// Family, port, flow info, address and scope ID.
+		if err = packet.require(28); err != nil {
+			return
+		}
 		packet.GetLittleShort()
 		port = packet.GetUnsignedShort()
 		packet.GetInt()
-		address = string(packet.Get(16))
-		packet.GetInt()
+		address = net.IP(packet.Get(16)).String()
+		if scopeId := packet.GetInt(); scopeId != 0 {
+			address += "%" + strconv.Itoa(int(scopeId))
+		}
 	}
 	return
 }
@@ -82,17 +109,58 @@ func (packet *Packet) PutAddress(address string, port uint16, ipVersion byte) {
 	switch ipVersion {
 	default:
 	case 4:
-		var stringArr = strings.Split(address, ".")
-		for _, str := range stringArr {
-			var digit, _ = strconv.Atoi(str)
-			packet.PutByte(byte(digit))
+		var ip = net.ParseIP(address).To4()
+		if ip == nil {
+			ip = net.IPv4zero.To4()
+		}
+		for _, part := range ip {
+			packet.PutByte(^part)
 		}
 		packet.PutUnsignedShort(port)
 	case 6:
-		packet.PutLittleShort(23)
+		var host, zone = splitZone(address)
+		var ip = net.ParseIP(host).To16()
+		if ip == nil {
+			ip = net.IPv6unspecified
+		}
+		packet.PutLittleShort(AddressFamilyIPv6)
 		packet.PutUnsignedShort(port)
 		packet.PutInt(0)
-		packet.PutBytes([]byte(address))
-		packet.PutInt(0)
+		packet.PutBytes(ip)
+		packet.PutInt(scopeId(zone))
+	}
+}
+
+// AddressVersion returns the IP version of the given address, either 4 or 6.
+// IPv4-mapped IPv6 addresses are treated as IPv4 addresses.
+func AddressVersion(address string) byte {
+	var host, _ = splitZone(address)
+	var ip = net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return 6
+	}
+	return 4
+}
+
+// splitZone splits an IPv6 address into the address and its zone, if any.
+func splitZone(address string) (host string, zone string) {
+	if i := strings.LastIndexByte(address, '%'); i != -1 {
+		return address[:i], address[i+1:]
+	}
+	return address, ""
+}
+
+// scopeId returns the scope ID for an IPv6 zone.
+// Zones may either be numeric or the name of a network interface.
+func scopeId(zone string) int32 {
+	if zone == "" {
+		return 0
+	}
+	if id, err := strconv.Atoi(zone); err == nil {
+		return int32(id)
+	}
+	if iface, err := net.InterfaceByName(zone); err == nil {
+		return int32(iface.Index)
 	}
+	return 0
 }
@@ -20,8 +20,17 @@ func (message *UnconnectedMessage) PutMagic() {
 	message.PutBytes(magic)
 }
 
-func (message *UnconnectedMessage) ReadMagic() {
+// ReadMagic reads the offline message magic.
+// ErrInvalidMagic is returned if the magic read does not match the offline message magic.
+func (message *UnconnectedMessage) ReadMagic() error {
+	if err := message.require(16); err != nil {
+		return err
+	}
 	message.magic = binutils.Read(&message.Buffer, &message.Offset, 16)
+	if !message.HasValidMagic() {
+		return ErrInvalidMagic
+	}
+	return nil
 }
 
 func (message *UnconnectedMessage) HasValidMagic() bool {
@@ -17,8 +17,11 @@ func (ping *UnconnectedPing) Encode() {
 	ping.PutMagic()
 }
 
-func (ping *UnconnectedPing) Decode() {
+func (ping *UnconnectedPing) Decode() error {
 	ping.DecodeStep()
+	if err := ping.require(8); err != nil {
+		return err
+	}
 	ping.PingTime = ping.GetLong()
-	ping.ReadMagic()
+	return ping.ReadMagic()
 }
@@ -22,11 +22,23 @@ func (pong *UnconnectedPong) Encode() {
 	pong.PutBytes([]byte(pong.PongData))
 }
 
-func (pong *UnconnectedPong) Decode() {
+func (pong *UnconnectedPong) Decode() error {
 	pong.DecodeStep()
+	if err := pong.require(16); err != nil {
+		return err
+	}
 	pong.PingTime = pong.GetLong()
 	pong.ServerId = pong.GetLong()
-	pong.ReadMagic()
-	l := pong.GetShort()
-	pong.PongData = string(pong.Get(int(l)))
+	if err := pong.ReadMagic(); err != nil {
+		return err
+	}
+	if err := pong.require(2); err != nil {
+		return err
+	}
+	l := int(pong.GetUnsignedShort())
+	if err := pong.require(l); err != nil {
+		return err
+	}
+	pong.PongData = string(pong.Get(l))
+	return nil
 }
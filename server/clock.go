@@ -0,0 +1,57 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// TickInterval is the interval at which a running manager ticks its sessions.
+const TickInterval = time.Second / 80
+
+// A Clock provides the current time to a manager and its sessions.
+// All timeouts, retransmissions and rate limits of a manager are measured using its clock.
+type Clock interface {
+	// Now returns the current time of the clock.
+	Now() time.Time
+}
+
+// SystemClock is a clock that returns the time of the system.
+// It is the default clock of a manager.
+type SystemClock struct{}
+
+// Now returns the current time of the system.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// A VirtualClock is a clock of which the time only changes when it is advanced.
+// Virtual clocks make managers deterministic, when they are ticked manually instead of being run.
+// A virtual clock is safe for concurrent use.
+type VirtualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewVirtualClock returns a new virtual clock starting at the given time.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the current time of the virtual clock.
+func (clock *VirtualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// Advance advances the time of the virtual clock by the given duration.
+func (clock *VirtualClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(duration)
+	clock.mutex.Unlock()
+}
+
+// millis returns the time in milliseconds.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// MaximumCongestionWindow is the maximum congestion window of the sliding window, in bytes.
+const MaximumCongestionWindow = 1 << 22
+
+// A CongestionController limits the amount of unacknowledged bytes in flight for a session.
+// Priority queues stop flushing once the bytes in flight reach the window of the controller,
+// and packets left over wait in the queue until enough bytes have been acknowledged.
+// Packets sent with immediate priority, ACKs and NAKs are not limited by the controller.
+// Implementations must be safe for concurrent use.
+type CongestionController interface {
+	// Window returns the congestion window,
+	// which is the maximum amount of unacknowledged bytes that may be in flight.
+	Window() int
+	// OnAcknowledged gets called with the amount of bytes acknowledged by an ACK,
+	// and the RTT statistics of the session after processing the ACK.
+	OnAcknowledged(bytes int, rtt RTTStats)
+	// OnLoss gets called once datagrams are found lost.
+	// Timeout is true if the loss was detected by an expired retransmission timeout,
+	// and false if it was reported by a NAK.
+	OnLoss(timeout bool, rtt RTTStats)
+}
+
+// NewCongestionControllerFunction is a function that returns a new congestion controller
+// for a session with the given MTU size.
+type NewCongestionControllerFunction func(mtuSize int16) CongestionController
+
+// A SlidingWindow is a congestion controller similar to the sliding window of RakNet.
+// The window grows exponentially in slow start until the first loss,
+// after which it grows by about one MTU size per RTT.
+// The window is halved at most once per RTT when datagrams are NAKed,
+// and collapses to its minimum when a retransmission timeout expires.
+type SlidingWindow struct {
+	sync.Mutex
+	// Clock is the clock the time between two window decreases is measured with.
+	// Sessions set it to the clock of their manager.
+	Clock Clock
+
+	mtuSize      int
+	window       int
+	threshold    int
+	lastDecrease time.Time
+}
+
+// NewSlidingWindow returns a new sliding window congestion controller for the given MTU size.
+// The initial window is ten times the MTU size.
+func NewSlidingWindow(mtuSize int16) CongestionController {
+	return &SlidingWindow{Clock: SystemClock{}, mtuSize: int(mtuSize), window: int(mtuSize) * 10, threshold: MaximumCongestionWindow}
+}
+
+// Window returns the current congestion window in bytes.
+func (window *SlidingWindow) Window() int {
+	window.Lock()
+	defer window.Unlock()
+	return window.window
+}
+
+// OnAcknowledged grows the window by the bytes acknowledged in slow start,
+// and by a fraction of the MTU size in congestion avoidance.
+func (window *SlidingWindow) OnAcknowledged(bytes int, rtt RTTStats) {
+	window.Lock()
+	defer window.Unlock()
+	if window.window < window.threshold {
+		window.window += bytes
+	} else {
+		window.window += window.mtuSize * bytes / window.window
+	}
+	if window.window > MaximumCongestionWindow {
+		window.window = MaximumCongestionWindow
+	}
+}
+
+// OnLoss shrinks the window after a loss.
+func (window *SlidingWindow) OnLoss(timeout bool, rtt RTTStats) {
+	window.Lock()
+	defer window.Unlock()
+	now := window.Clock.Now()
+	if !timeout && now.Sub(window.lastDecrease) < rtt.Smoothed {
+		return
+	}
+	window.lastDecrease = now
+	window.threshold = window.window / 2
+	if window.threshold < window.mtuSize*2 {
+		window.threshold = window.mtuSize * 2
+	}
+	if timeout {
+		window.window = window.mtuSize * 2
+	} else {
+		window.window = window.threshold
+	}
+}
+
+// UnlimitedWindow is a congestion controller that never limits the bytes in flight.
+// It may be used to disable congestion control, or as a baseline to compare algorithms against.
+type UnlimitedWindow struct{}
+
+// NewUnlimitedWindow returns a new unlimited congestion controller.
+func NewUnlimitedWindow(mtuSize int16) CongestionController {
+	return UnlimitedWindow{}
+}
+
+// Window returns the maximum integer, so that the bytes in flight are never limited.
+func (UnlimitedWindow) Window() int {
+	return int(^uint(0) >> 1)
+}
+
+// OnAcknowledged does nothing for an unlimited window.
+func (UnlimitedWindow) OnAcknowledged(bytes int, rtt RTTStats) {}
+
+// OnLoss does nothing for an unlimited window.
+func (UnlimitedWindow) OnLoss(timeout bool, rtt RTTStats) {}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/irmine/goraklib/protocol"
+)
+
+// ConnBacklog is the maximum amount of messages received by a connection that have not yet been read.
+// Sessions sending messages while the backlog of their connection is full are disconnected,
+// as the messages can not be dropped without breaking the stream of the connection.
+const ConnBacklog = 256
+
+// A Conn is a net.Conn of a single RakNet session.
+// Every Read reads a single message sent by the session, and every Write sends a single message.
+// Messages are sent with the Reliability, Priority and OrderChannel of the connection.
+type Conn struct {
+	// Reliability is the reliability messages get written with.
+	// The default reliability is ReliabilityReliableOrdered.
+	Reliability byte
+	// Priority is the priority messages get written with.
+	// The default priority is PriorityMedium.
+	Priority Priority
+	// OrderChannel is the order channel messages get written on.
+	OrderChannel byte
+
+	session    *Session
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	messages   chan []byte
+
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closedLocal bool
+	reason      DisconnectReason
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+// newConn returns a new connection of the session, listening on the local address.
+func newConn(session *Session, localAddr net.Addr) *Conn {
+	return &Conn{Reliability: protocol.ReliabilityReliableOrdered, Priority: PriorityMedium,
+		session: session, localAddr: localAddr, remoteAddr: session.UDPAddr,
+		messages: make(chan []byte, ConnBacklog), closed: make(chan struct{}),
+		readDeadline: newDeadline(), writeDeadline: newDeadline()}
+}
+
+// Session returns the session of the connection.
+// The session should not be used once the connection is closed.
+func (conn *Conn) Session() *Session {
+	return conn.session
+}
+
+// DisconnectReason returns the reason the session of the connection got disconnected with.
+// The reason is only valid once Read returned io.EOF.
+func (conn *Conn) DisconnectReason() DisconnectReason {
+	return conn.reason
+}
+
+// Read reads a single message of the session into the buffer.
+// If the buffer is smaller than the message, the rest of the message is discarded and io.ErrShortBuffer is returned.
+// Read returns io.EOF once the session disconnected and all messages have been read,
+// and net.ErrClosed once the connection has been closed.
+func (conn *Conn) Read(buffer []byte) (int, error) {
+	message, err := conn.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	n := copy(buffer, message)
+	if n < len(message) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// ReadMessage reads a single message of the session, and returns it as a whole.
+// ReadMessage returns the same errors as Read.
+func (conn *Conn) ReadMessage() ([]byte, error) {
+	select {
+	case message := <-conn.messages:
+		return message, nil
+	default:
+	}
+	select {
+	case message := <-conn.messages:
+		return message, nil
+	case <-conn.closed:
+		if conn.closedLocal {
+			return nil, net.ErrClosed
+		}
+		select {
+		case message := <-conn.messages:
+			return message, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-conn.readDeadline.wait():
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Write writes the buffer to the session as a single message.
+// The first byte of the message must not be the ID of a RakNet internal packet, such as 0x00 or 0x15,
+// as the other end would handle the message as such.
+// Empty messages are not sent.
+// Write blocks while the queue of the priority of the connection is full, and returns os.ErrDeadlineExceeded
+// without sending the message if the write deadline passes first.
+func (conn *Conn) Write(buffer []byte) (int, error) {
+	select {
+	case <-conn.closed:
+		return 0, net.ErrClosed
+	case <-conn.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+	// The message is only queued once all of its sub packets fit, so that a message is never partially queued.
+	queue := conn.session.Queues.get(conn.Priority)
+	for !queue.hasRoom(len(buffer), conn.session) {
+		timer := time.NewTimer(TickInterval)
+		select {
+		case <-conn.closed:
+			timer.Stop()
+			return 0, net.ErrClosed
+		case <-conn.session.done:
+			timer.Stop()
+			return 0, net.ErrClosed
+		case <-conn.writeDeadline.wait():
+			timer.Stop()
+			return 0, os.ErrDeadlineExceeded
+		case <-timer.C:
+		}
+	}
+	conn.session.SendPacket(message(append([]byte{}, buffer...)), conn.Reliability, conn.Priority, conn.OrderChannel)
+	return len(buffer), nil
+}
+
+// Close closes the connection, and disconnects its session.
+// Any blocked Read or Write calls are unblocked and return net.ErrClosed.
+func (conn *Conn) Close() error {
+	err := net.ErrClosed
+	conn.closeOnce.Do(func() {
+		conn.closedLocal = true
+		conn.reason = DisconnectReasonKicked
+		close(conn.closed)
+		conn.session.Disconnect(DisconnectReasonKicked)
+		err = nil
+	})
+	return err
+}
+
+// LocalAddr returns the local address of the listener of the connection.
+func (conn *Conn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+// RemoteAddr returns the UDP address of the session of the connection.
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// SetDeadline sets both the read and write deadline of the connection.
+func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	conn.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline of Read calls, including calls currently blocked.
+// A zero time disables the deadline.
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write calls, including calls currently blocked.
+// A zero time disables the deadline.
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	conn.writeDeadline.set(t)
+	return nil
+}
+
+// receive queues a message of the session to be read, and returns false if the backlog is full.
+// receive never blocks, so that a connection that is not read from never blocks the handling of its session.
+// Messages received once the connection is closed are dropped.
+func (conn *Conn) receive(packet []byte) bool {
+	select {
+	case <-conn.closed:
+		return true
+	default:
+	}
+	select {
+	case conn.messages <- packet:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnect closes the connection because its session got disconnected with the given reason.
+// Messages already received can still be read.
+func (conn *Conn) disconnect(reason DisconnectReason) {
+	conn.closeOnce.Do(func() {
+		conn.reason = reason
+		close(conn.closed)
+	})
+}
+
+// message is a message written to a connection.
+type message []byte
+
+func (message message) Encode() {}
+
+func (message message) GetBuffer() []byte {
+	return message
+}
+
+// A deadline is a deadline of a connection, which may be changed while calls are waiting for it.
+type deadline struct {
+	sync.Mutex
+	timer    *time.Timer
+	exceeded chan struct{}
+}
+
+// newDeadline returns a new deadline that is disabled.
+func newDeadline() *deadline {
+	return &deadline{exceeded: make(chan struct{})}
+}
+
+// set sets the time of the deadline. A zero time disables the deadline.
+func (deadline *deadline) set(t time.Time) {
+	deadline.Lock()
+	defer deadline.Unlock()
+	if deadline.timer != nil && !deadline.timer.Stop() {
+		// The timer already fired, so wait for it to have closed the channel.
+		<-deadline.exceeded
+	}
+	deadline.timer = nil
+
+	exceeded := false
+	select {
+	case <-deadline.exceeded:
+		exceeded = true
+	default:
+	}
+	if t.IsZero() {
+		if exceeded {
+			deadline.exceeded = make(chan struct{})
+		}
+		return
+	}
+	if duration := time.Until(t); duration > 0 {
+		if exceeded {
+			deadline.exceeded = make(chan struct{})
+		}
+		channel := deadline.exceeded
+		deadline.timer = time.AfterFunc(duration, func() {
+			close(channel)
+		})
+		return
+	}
+	if !exceeded {
+		close(deadline.exceeded)
+	}
+}
+
+// wait returns a channel that is closed once the deadline is exceeded.
+func (deadline *deadline) wait() chan struct{} {
+	deadline.Lock()
+	defer deadline.Unlock()
+	return deadline.exceeded
+}
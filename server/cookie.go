@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// CookieInterval is the interval at which the security cookie of an address changes.
+// Cookies of both the current and the previous interval are accepted,
+// so that a cookie is valid for at least one interval after it was sent.
+const CookieInterval = time.Second * 10
+
+// newCookieKey returns a new random key to sign security cookies with.
+func newCookieKey() []byte {
+	key := make([]byte, sha256.Size)
+	rand.Read(key)
+	return key
+}
+
+// cookieInterval returns the current cookie interval.
+func (manager *Manager) cookieInterval() int64 {
+	return manager.Clock.Now().UnixNano() / int64(CookieInterval)
+}
+
+// cookie returns the security cookie of an address for the given interval.
+// The cookie is an HMAC of the address and interval, so that it does not need to be stored.
+func (manager *Manager) cookie(addr *net.UDPAddr, interval int64) uint32 {
+	mac := hmac.New(sha256.New, manager.cookieKey)
+	mac.Write([]byte(addressKey(addr)))
+	binary.Write(mac, binary.BigEndian, interval)
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// ValidCookie checks if the security cookie sent by an address is valid.
+// Cookies are valid if they were sent to the address in the current or previous cookie interval.
+func (manager *Manager) ValidCookie(addr *net.UDPAddr, cookie uint32) bool {
+	interval := manager.cookieInterval()
+	return subtle.ConstantTimeEq(int32(cookie), int32(manager.cookie(addr, interval))) == 1 ||
+		subtle.ConstantTimeEq(int32(cookie), int32(manager.cookie(addr, interval-1))) == 1
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+)
+
+// DisconnectTimeout is the maximum duration Disconnect waits for the disconnect notification
+// to be acknowledged, after which the session is closed regardless.
+const DisconnectTimeout = time.Second
+
+const (
+	// DisconnectReasonTimeout is the reason of sessions that stopped responding,
+	// or of which a datagram could not be delivered within the retransmission limit.
+	DisconnectReasonTimeout DisconnectReason = iota
+	// DisconnectReasonClientQuit is the reason of sessions of which the other end disconnected itself,
+	// by sending a disconnect notification.
+	DisconnectReasonClientQuit
+	// DisconnectReasonKicked is the reason of sessions disconnected by the program,
+	// either using Disconnect or FlagForClose.
+	DisconnectReasonKicked
+	// DisconnectReasonShutdown is the reason of sessions closed because the manager shut down.
+	DisconnectReasonShutdown
+)
+
+// DisconnectReason is the reason a session got disconnected.
+// It is passed to the DisconnectFunction of the manager.
+type DisconnectReason byte
+
+// String returns a human readable description of the disconnect reason.
+func (reason DisconnectReason) String() string {
+	switch reason {
+	case DisconnectReasonTimeout:
+		return "timeout"
+	case DisconnectReasonClientQuit:
+		return "client quit"
+	case DisconnectReasonKicked:
+		return "kicked"
+	case DisconnectReasonShutdown:
+		return "shutdown"
+	}
+	return "unknown"
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// ListenerBacklog is the maximum amount of connected sessions waiting to be accepted by a listener.
+// Sessions connecting while the backlog is full are disconnected.
+const ListenerBacklog = 64
+
+// A Listener is a net.Listener of RakNet sessions.
+// Every session that connects to the listener is accepted as a Conn,
+// which reads and writes whole messages instead of using the functions of the manager.
+type Listener struct {
+	// Manager is the manager of the listener, which may be used to configure it further.
+	// The PacketFunction, ConnectFunction and DisconnectFunction of the manager are used by the listener,
+	// and must not be changed.
+	Manager *Manager
+
+	addr      net.Addr
+	conns     chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	sync.Mutex
+	sessions map[*Session]*Conn
+}
+
+// Listen starts a new manager listening on the given network and address, and returns a listener of its sessions.
+// The network must be "udp", "udp4" or "udp6", and the address should be of the form "host:port".
+func Listen(network, address string) (*Listener, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return ListenPacketConn(conn), nil
+}
+
+// ListenPacketConn starts a new manager on the given packet connection, and returns a listener of its sessions.
+// The connection is closed once the listener is closed.
+func ListenPacketConn(conn net.PacketConn) *Listener {
+	manager := NewManager()
+	listener := NewListener(manager)
+	listener.addr = conn.LocalAddr()
+	manager.Serve(conn)
+	return listener
+}
+
+// NewListener returns a new listener of the sessions of the manager.
+// The functions of the manager are replaced by those of the listener.
+// The manager is not started, and should be started using Start, Serve or Run.
+func NewListener(manager *Manager) *Listener {
+	listener := &Listener{Manager: manager, conns: make(chan *Conn, ListenerBacklog), closed: make(chan struct{}), sessions: make(map[*Session]*Conn)}
+	manager.ConnectFunction = listener.handleConnect
+	manager.PacketFunction = listener.handlePacket
+	manager.DisconnectFunction = listener.handleDisconnect
+	return listener
+}
+
+// Accept waits for the next session to connect, and returns a connection of it.
+// Accept returns net.ErrClosed once the listener has been closed.
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and gracefully shuts down its manager.
+// All sessions, including those of connections already accepted, are disconnected.
+// Close waits at most the DisconnectTimeout for the sessions to disconnect.
+func (listener *Listener) Close() error {
+	err := net.ErrClosed
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+		ctx, cancel := context.WithTimeout(context.Background(), DisconnectTimeout)
+		defer cancel()
+		err = listener.Manager.Shutdown(ctx)
+	})
+	return err
+}
+
+// Addr returns the local address the listener is listening on.
+func (listener *Listener) Addr() net.Addr {
+	listener.Lock()
+	defer listener.Unlock()
+	if listener.addr == nil && listener.Manager.Server.HasStarted() {
+		listener.addr = listener.Manager.Server.LocalAddr()
+	}
+	return listener.addr
+}
+
+// handleConnect creates a connection for a newly connected session, and queues it to be accepted.
+// Sessions that already have a connection are ignored, and sessions connecting while the backlog is full
+// are disconnected, so that the session handling the connect is never blocked.
+func (listener *Listener) handleConnect(session *Session) {
+	conn := newConn(session, listener.Addr())
+	listener.Lock()
+	if _, ok := listener.sessions[session]; ok {
+		listener.Unlock()
+		return
+	}
+	listener.sessions[session] = conn
+	listener.Unlock()
+	select {
+	case listener.conns <- conn:
+	default:
+		session.logger().Warn("listener backlog full")
+		listener.Lock()
+		delete(listener.sessions, session)
+		listener.Unlock()
+		session.Disconnect(DisconnectReasonKicked)
+	}
+}
+
+// handlePacket passes a message of a session to its connection.
+// Sessions are disconnected if the backlog of their connection is full.
+func (listener *Listener) handlePacket(packet []byte, session *Session) {
+	listener.Lock()
+	conn, ok := listener.sessions[session]
+	listener.Unlock()
+	if ok && !conn.receive(packet) {
+		if session.disconnecting.Load() == nil {
+			session.logger().Warn("connection backlog full")
+		}
+		session.Disconnect(DisconnectReasonKicked)
+	}
+}
+
+// handleDisconnect closes the connection of a disconnected session.
+func (listener *Listener) handleDisconnect(session *Session, reason DisconnectReason) {
+	listener.Lock()
+	conn, ok := listener.sessions[session]
+	delete(listener.sessions, session)
+	listener.Unlock()
+	if ok {
+		conn.disconnect(reason)
+	}
+}
@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net"
 	"time"
 	"math/rand"
 	"github.com/irmine/goraklib/protocol"
-	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -16,26 +19,65 @@ const (
 	// MinimumMTUSize is the minimum packet size.
 	// Any MTU size below this will get set to the minimum.
 	MinimumMTUSize = 400
+	// MaximumSplitCount is the maximum amount of fragments a packet may be split into.
+	// Packets split into more fragments are treated as malformed.
+	MaximumSplitCount = 1024
+	// BannedReplyInterval is the minimum interval between connection banned replies sent to a single blocked IP.
+	BannedReplyInterval = time.Second
 )
 
+const (
+	// MalformedPacketIgnore makes the manager drop malformed packets without any further action.
+	MalformedPacketIgnore MalformedPacketPolicy = iota
+	// MalformedPacketBlock makes the manager block the IP of the sender of a malformed packet
+	// for the MalformedPacketBlockDuration.
+	MalformedPacketBlock
+	// MalformedPacketDisconnect makes the manager close the session of the sender of a malformed packet.
+	// Malformed packets of addresses without a session are dropped.
+	MalformedPacketDisconnect
+)
+
+// MalformedPacketPolicy is the policy applied to addresses that send malformed packets.
+type MalformedPacketPolicy byte
+
+// errTooManySplits is the error of a packet split into more than MaximumSplitCount fragments.
+var errTooManySplits = errors.New("packet split into too many fragments")
+
+// DefaultSupportedProtocols are the RakNet protocol versions supported by a new manager.
+var DefaultSupportedProtocols = []byte{8, 9, 10, 11}
+
 // Manager manages a UDP server and its components.
 type Manager struct {
 	Server   *UDPServer
-	Sessions SessionManager
+	Sessions *SessionManager
 
 	// PongData is the data returned when the server gets an unconnected ping.
 	PongData string
-	// Security ensures a secure connection between a pair of systems.
-	// Setting this to false is often the best idea for mobile devices.
+	// Security enables security cookies during the handshake.
+	// A cookie is sent in the open connection reply 1, which the client must send back in its open connection request 2.
+	// Open connection requests 2 with an invalid cookie are ignored, so that spoofed addresses can not create sessions.
 	Security bool
 	// Encryption encrypts all packets sent over RakNet.
 	// Encryption should be disabled if used for Minecraft.
 	Encryption bool
+	// MaxConnections is the maximum amount of sessions the manager holds at once.
+	// Clients connecting while the maximum is reached are sent a no free incoming connections reply.
+	// The amount of sessions is unlimited if MaxConnections is 0.
+	MaxConnections int
+	// MaxConnectionsPerIP is the maximum amount of sessions of a single IP address.
+	// Clients connecting from an IP address that reached the maximum are sent a no free incoming connections reply.
+	// The amount of sessions per IP address is unlimited if MaxConnectionsPerIP is 0.
+	MaxConnectionsPerIP int
+	// SupportedProtocols are the RakNet protocol versions supported by the manager.
+	// Clients requesting a connection with any other protocol version are sent an incompatible
+	// protocol version reply, holding the last protocol version of the slice.
+	// Clients of all protocol versions are accepted if the slice is empty.
+	SupportedProtocols []byte
 	// ServerId is a random ID to identify servers. It is randomly generated for each manager.
 	ServerId int64
 	// Running specifies the running state of the manager.
 	// The manager will automatically stop working if the running state is false.
-	Running bool
+	Running atomic.Bool
 	// CurrentTick is the current tick of the manager. This current Tick increments for every
 	// time the manager ticks.
 	CurrentTick int64
@@ -43,6 +85,22 @@ type Manager struct {
 	// Timed out sessions get closed and removed immediately.
 	// The default timeout duration is 6 seconds.
 	TimeoutDuration time.Duration
+	// Clock is the clock of the manager, which all timeouts of the manager and its sessions are measured with.
+	// The default clock is the SystemClock. A VirtualClock may be used to test managers deterministically,
+	// in which case the manager should not be run, but be ticked and given packets using Tick and HandlePacket.
+	// The clock must be set before any sessions are created.
+	Clock Clock
+	// HandleSynchronously makes sessions handle the datagrams they receive on the goroutine ticking the manager,
+	// instead of handling every datagram on a goroutine of its own.
+	// Handling synchronously makes a manager deterministic, but slow PacketFunctions delay the ticks of the manager.
+	HandleSynchronously bool
+
+	// Logger is the logger events of the manager and its sessions are logged to.
+	// Handshake steps and retransmissions are logged at debug level, connects and disconnects at info level,
+	// and timeouts, blocks and malformed packets at warn level.
+	// Events of sessions hold the address and client ID of the session.
+	// The default logger is slog.Default(). Set a logger with a discarding handler to silence the manager.
+	Logger *slog.Logger
 
 	// RawPacketFunction gets called when a raw packet is processed.
 	// The address given is the address of the sender, and the byte array the buffer of the packet.
@@ -55,13 +113,44 @@ type Manager struct {
 	// and packets of the game protocol start to get sent.
 	ConnectFunction		 func(session *Session)
 	// DisconnectFunction gets called with the associated session on a disconnect.
-	// This disconnect may be either client initiated or server initiated.
-	DisconnectFunction	 func(session *Session)
+	// This disconnect may be either client initiated or server initiated,
+	// and the reason of the disconnect is passed along.
+	DisconnectFunction	 func(session *Session, reason DisconnectReason)
+	// MalformedPacketPolicy is the policy applied to addresses that send packets that could not be decoded.
+	// The default policy is MalformedPacketBlock.
+	MalformedPacketPolicy MalformedPacketPolicy
+	// MalformedPacketBlockDuration is the duration addresses are blocked for with the MalformedPacketBlock policy.
+	// The default duration is 5 seconds.
+	MalformedPacketBlockDuration time.Duration
+	// RateLimiter limits the amount of packets of every IP address, and blocks addresses that exceed the limits.
+	// Rate limiting is disabled if the rate limiter is nil, which is the default, as clients sharing an IP address
+	// behind a NAT or proxy share their limits. Set it to NewRateLimiter() to enable rate limiting.
+	RateLimiter *RateLimiter
+	// NewCongestionController gets called to create the congestion controller of every new session.
+	// The default is the RakNet-like sliding window. NewUnlimitedWindow may be used to disable congestion control.
+	NewCongestionController NewCongestionControllerFunction
+	// MetricsMaxSessions is the maximum amount of sessions the metrics handler exports series labelled with the session of.
+	// Every session exported is a separate time series, so that the amount is capped to bound the cardinality of the metrics.
+	// Only metrics aggregated over all sessions are exported if MetricsMaxSessions is 0, which is the default.
+	MetricsMaxSessions int
+
+	// reading and ticking are the loops of the current run of the manager, protected by the loop mutex.
+	// New loops are started every time the manager runs, so that it can be restarted once stopped.
+	reading   *managerLoop
+	ticking   *managerLoop
+	loopMutex sync.Mutex
+	// shuttingDown indicates if the manager is shutting down.
+	// No new sessions are accepted while shutting down.
+	shuttingDown atomic.Bool
 
 	*sync.RWMutex
-	// ipBlocks is a field containing all blocked addresses.
+	// ipBlocks holds the block of every blocked IP address.
 	// Blocked addresses are ignored completely; Their packets are not processed.
-	ipBlocks map[string]*net.UDPAddr
+	ipBlocks map[string]ipBlock
+	// cookieKey is the random key security cookies are signed with.
+	cookieKey []byte
+	// counters holds the traffic counters of the manager.
+	counters counters
 }
 
 // NewManager returns a new Manager for a UDP Server.
@@ -72,10 +161,17 @@ func NewManager() *Manager {
 		RawPacketFunction: func(packet []byte, addr *net.UDPAddr) {},
 		PacketFunction: func(packet []byte, session *Session) {},
 		ConnectFunction: func(session *Session) {},
-		DisconnectFunction: func(session *Session) {},
-		ipBlocks: make(map[string]*net.UDPAddr),
+		DisconnectFunction: func(session *Session, reason DisconnectReason) {},
+		NewCongestionController: NewSlidingWindow,
+		Logger: slog.Default(),
+		MalformedPacketPolicy: MalformedPacketBlock,
+		MalformedPacketBlockDuration: time.Second * 5,
+		SupportedProtocols: append([]byte{}, DefaultSupportedProtocols...),
+		ipBlocks: make(map[string]ipBlock),
 		RWMutex: &sync.RWMutex{},
 		TimeoutDuration: time.Second * 6,
+		Clock: SystemClock{},
+		cookieKey: newCookieKey(),
 	}
 }
 
@@ -83,39 +179,191 @@ func NewManager() *Manager {
 // Start returns an error if any might have occurred during starting.
 // The manager will keep processing incoming packets until it has been Stop()ed.
 func (manager *Manager) Start(address string, port int) error {
-	manager.Running = true
 	err := manager.Server.Start(address, port)
+	manager.Run()
+	return err
+}
 
-	go func() {
-		for manager.Running {
-			manager.processIncomingPacket()
+// Run makes the manager start processing incoming packets and ticking its sessions,
+// using a UDP server that has already been started, or a packet connection that has been set.
+// Run does not block, and the manager keeps running until it has been Stop()ed or Shutdown.
+func (manager *Manager) Run() {
+	if manager.RateLimiter != nil {
+		manager.RateLimiter.Clock = manager.Clock
+	}
+	manager.Running.Store(true)
+	manager.loopMutex.Lock()
+	manager.reading = startLoop(manager.readPackets)
+	manager.ticking = startLoop(manager.tickSessions)
+	manager.loopMutex.Unlock()
+}
+
+// Serve makes the manager use the given packet connection, and runs it.
+// The connection may be any net.PacketConn, such as an in-memory connection or a wrapper around a UDP connection,
+// and is closed once the manager stops. Packets read that are not RakNet packets are passed to the RawPacketFunction,
+// so that the connection can be shared with other protocols, such as a query protocol.
+// Serve does not block.
+func (manager *Manager) Serve(conn net.PacketConn) {
+	manager.Server.PacketConn = conn
+	manager.Run()
+}
+
+// Stop makes the manager stop processing incoming packets and ticking its sessions,
+// and closes the UDP server. Stop does not wait for the manager to stop,
+// and does not notify nor close the sessions of the manager.
+// Shutdown should be used to gracefully shut down the manager instead.
+func (manager *Manager) Stop() {
+	manager.Running.Store(false)
+	reading, ticking := manager.loops()
+	if reading != nil {
+		reading.stop()
+		ticking.stop()
+	}
+	manager.Server.Close()
+}
+
+// Shutdown gracefully shuts down the manager.
+// Every session is sent a disconnect notification, after which Shutdown waits until the queues
+// of all sessions have been flushed and acknowledged, and until all packets being handled have been handled.
+// All sessions then get closed, calling the DisconnectFunction with DisconnectReasonShutdown, and the UDP server is closed.
+// Shutdown returns once the manager has shut down, or once the context expires,
+// in which case the manager is closed without waiting any further and the error of the context is returned.
+// The current tick of the manager is always waited for, even once the context expired,
+// so that sessions are never closed while they are being ticked.
+// Shutdown must not be called from any of the functions of the manager, such as the PacketFunction.
+// The manager may be started again once it has shut down.
+func (manager *Manager) Shutdown(ctx context.Context) error {
+	manager.shuttingDown.Store(true)
+	defer manager.shuttingDown.Store(false)
+	reading, ticking := manager.loops()
+
+	sessions := manager.Sessions.GetSessions()
+	manager.Logger.Info("shutting down", "sessions", len(sessions))
+	for _, session := range sessions {
+		session.notifyDisconnect()
+	}
+	err := waitUntil(ctx, func() bool {
+		for _, session := range sessions {
+			if !session.IsClosed() && !session.isFlushed() {
+				return false
+			}
 		}
-	}()
-	go manager.tickSessions()
+		return true
+	})
+	if ticking != nil {
+		ticking.stop()
+		ticking.wait(context.Background())
+	}
+	if err == nil {
+		err = waitUntil(ctx, func() bool {
+			for _, session := range sessions {
+				if !session.IsClosed() && session.ReceiveWindow.Handling() > 0 {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	for _, session := range manager.Sessions.GetSessions() {
+		manager.Sessions.RemoveSession(session)
+		session.flagForClose(DisconnectReasonShutdown)
+		session.Close()
+	}
 
+	manager.Running.Store(false)
+	manager.Server.Close()
+	if reading != nil {
+		reading.stop()
+		if readErr := reading.wait(ctx); readErr != nil {
+			return readErr
+		}
+	}
+	manager.Server.PacketConn = nil
 	return err
 }
 
-// Stop makes the manager stop processing incoming packets.
-func (manager *Manager) Stop() {
-	manager.Running = false
+// loops returns the reading and ticking loops of the current run of the manager,
+// which are nil if the manager never ran.
+func (manager *Manager) loops() (*managerLoop, *managerLoop) {
+	manager.loopMutex.Lock()
+	defer manager.loopMutex.Unlock()
+	return manager.reading, manager.ticking
+}
+
+// HasFreeConnections checks if a new session may be created for the given UDP address,
+// taking both the MaxConnections and the MaxConnectionsPerIP into account.
+// Addresses that already have a session always have a free connection, as a repeated open connection request 2
+// keeps their session and only re-sends the reply.
+func (manager *Manager) HasFreeConnections(addr *net.UDPAddr) bool {
+	if manager.Sessions.SessionExists(addr) {
+		return true
+	}
+	if manager.MaxConnections > 0 && manager.Sessions.Count() >= manager.MaxConnections {
+		return false
+	}
+	if manager.MaxConnectionsPerIP > 0 && manager.Sessions.CountIP(addr.IP) >= manager.MaxConnectionsPerIP {
+		return false
+	}
+	return true
+}
+
+// handleMalformedPacket applies the malformed packet policy to the sender of a packet that could not be decoded.
+func (manager *Manager) handleMalformedPacket(addr *net.UDPAddr, err error) {
+	switch manager.MalformedPacketPolicy {
+	case MalformedPacketIgnore:
+		manager.Logger.Debug("malformed packet", "address", addr.String(), "error", err)
+	case MalformedPacketBlock:
+		manager.Logger.Warn("malformed packet", "address", addr.String(), "error", err)
+		manager.BlockIP(addr, manager.MalformedPacketBlockDuration)
+	case MalformedPacketDisconnect:
+		manager.Logger.Warn("malformed packet", "address", addr.String(), "error", err)
+		if session, ok := manager.Sessions.GetSession(addr); ok {
+			session.FlagForClose()
+		}
+	}
+}
+
+// SupportsProtocol checks if the manager supports the given RakNet protocol version.
+func (manager *Manager) SupportsProtocol(protocol byte) bool {
+	if len(manager.SupportedProtocols) == 0 {
+		return true
+	}
+	for _, supported := range manager.SupportedProtocols {
+		if supported == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// ipBlock is the block of a single IP address.
+type ipBlock struct {
+	// until is the time the IP is blocked until.
+	until time.Time
+	// replied is the time a connection banned reply was last sent to the IP.
+	replied time.Time
 }
 
 // BlockIP blocks the IP of the given UDP address,
 // ignoring any further packets until the duration runs out.
+// Blocked addresses attempting to connect are sent a connection banned reply, at most once every BannedReplyInterval.
+// Blocking an IP that is already blocked for longer does not shorten its block.
 func (manager *Manager) BlockIP(addr *net.UDPAddr, duration time.Duration) {
+	manager.Logger.Warn("IP blocked", "address", addr.IP.String(), "duration", duration)
+	until := manager.Clock.Now().Add(duration)
+	key := ipKey(addr.IP)
 	manager.Lock()
-	manager.ipBlocks[fmt.Sprint(addr.IP)] = addr
+	if block := manager.ipBlocks[key]; until.After(block.until) {
+		block.until = until
+		manager.ipBlocks[key] = block
+	}
 	manager.Unlock()
-	time.AfterFunc(duration, func() {
-		manager.UnblockIP(addr)
-	})
 }
 
 // UnblockIP unblocks the IP of the address and allows packets from the address once again.
 func (manager *Manager) UnblockIP(addr *net.UDPAddr) {
 	manager.Lock()
-	delete(manager.ipBlocks, fmt.Sprint(addr.IP))
+	delete(manager.ipBlocks, ipKey(addr.IP))
 	manager.Unlock()
 }
 
@@ -123,62 +371,157 @@ func (manager *Manager) UnblockIP(addr *net.UDPAddr) {
 // If true, packets are not processed of the address.
 func (manager *Manager) IsIPBlocked(addr *net.UDPAddr) bool {
 	manager.RLock()
-	_, ok := manager.ipBlocks[fmt.Sprint(addr.IP)]
+	block, ok := manager.ipBlocks[ipKey(addr.IP)]
 	manager.RUnlock()
-	return ok
+	return ok && manager.Clock.Now().Before(block.until)
+}
+
+// allowBannedReply checks if a connection banned reply may be sent to the IP of a blocked address,
+// and marks the reply as sent if so. Replies are sent at most once every BannedReplyInterval per IP,
+// so that blocked addresses can not use the manager to reflect traffic.
+func (manager *Manager) allowBannedReply(addr *net.UDPAddr) bool {
+	now := manager.Clock.Now()
+	key := ipKey(addr.IP)
+	manager.Lock()
+	defer manager.Unlock()
+	block, ok := manager.ipBlocks[key]
+	if !ok || (!block.replied.IsZero() && now.Sub(block.replied) < BannedReplyInterval) {
+		return false
+	}
+	block.replied = now
+	manager.ipBlocks[key] = block
+	return true
+}
+
+// pruneIPBlocks removes all blocks that ran out.
+func (manager *Manager) pruneIPBlocks() {
+	now := manager.Clock.Now()
+	manager.Lock()
+	for key, block := range manager.ipBlocks {
+		if !now.Before(block.until) {
+			delete(manager.ipBlocks, key)
+		}
+	}
+	manager.Unlock()
 }
 
 // tickSessions makes the server start ticking its sessions.
-// Sessions get ticked on an interval of 80 ticks per second.
-func (manager *Manager) tickSessions() {
-	ticker := time.NewTicker(time.Duration(time.Second / 80))
-	for range ticker.C {
-		if !manager.Running {
+// Sessions get ticked every TickInterval, which is 80 ticks per second, until the halt channel gets closed.
+func (manager *Manager) tickSessions(halt <-chan struct{}) {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-halt:
+			return
+		case <-ticker.C:
+		}
+		if !manager.Running.Load() {
 			return
 		}
-		for index, session := range manager.Sessions {
-			manager.updateSession(session, index)
+		manager.Tick()
+	}
+}
+
+// Tick ticks the manager once, updating all of its sessions.
+// The rate limiter and the blocked IP addresses are pruned every 80 ticks.
+// Running managers tick automatically, so Tick should only be called for managers that are not running,
+// such as managers driven by a VirtualClock.
+func (manager *Manager) Tick() {
+	for _, session := range manager.Sessions.GetSessions() {
+		manager.updateSession(session)
+	}
+	if manager.CurrentTick%80 == 0 {
+		if manager.RateLimiter != nil {
+			manager.RateLimiter.Prune()
+		}
+		manager.pruneIPBlocks()
+	}
+	manager.CurrentTick++
+}
+
+// readPackets makes the server process incoming packets,
+// until the manager stops running or the halt channel gets closed.
+func (manager *Manager) readPackets(halt <-chan struct{}) {
+	for manager.Running.Load() {
+		select {
+		case <-halt:
+			return
+		default:
 		}
-		manager.CurrentTick++
+		manager.processIncomingPacket()
 	}
 }
 
-// updateSession updates a session with session index.
+// updateSession updates a session.
 // The session will be ticked while it's open.
 // Sessions that have not responded for too long are timed out and
 // flagged for closing, and sessions flagged for closing will be cleaned up.
-func (manager *Manager) updateSession(session *Session, index string) {
+func (manager *Manager) updateSession(session *Session) {
 	session.Tick(manager.CurrentTick)
-	if time.Now().Sub(session.LastUpdate) > manager.TimeoutDuration {
-		session.FlagForClose()
+	if lastUpdate := session.GetLastUpdate(); !session.IsFlaggedForClose() && manager.Clock.Now().Sub(lastUpdate) > manager.TimeoutDuration {
+		session.logger().Warn("session timed out", "lastUpdate", lastUpdate)
+		session.flagForClose(DisconnectReasonTimeout)
 	}
-	if session.FlaggedForClose {
+	if session.IsFlaggedForClose() {
+		manager.Sessions.RemoveSession(session)
 		session.Close()
-		delete(manager.Sessions, index)
 	}
 }
 
-// processIncomingPacket processes any incoming packet from the UDP server.
-// Unconnected messages get handled freely, while any other packet gets passed to its owner session.
+// processIncomingPacket reads an incoming packet from the UDP server and handles it.
 func (manager *Manager) processIncomingPacket() {
 	buffer := make([]byte, 2048)
 	n, addr, err := manager.Server.Read(buffer)
 	if err != nil {
 		return
 	}
+	manager.HandlePacket(buffer[:n], addr)
+}
+
+// HandlePacket handles a packet sent by the given address.
+// Unconnected messages get handled freely, while any other packet gets passed to its owner session.
+// Running managers handle all packets read from their UDP server, so HandlePacket should only be called
+// for managers that are not running, such as managers driven by a VirtualClock.
+// The buffer must not be modified once passed.
+func (manager *Manager) HandlePacket(buffer []byte, addr *net.UDPAddr) {
+	n := len(buffer)
+	manager.counters.add(counterBytesReceived, n)
 	if manager.IsIPBlocked(addr) {
+		manager.counters.add(counterDropped, 1)
+		if n > 0 && (buffer[0] == protocol.IdOpenConnectionRequest1 || buffer[0] == protocol.IdOpenConnectionRequest2) {
+			manager.counters.add(counterHandshakeFailures, 1)
+			if !manager.allowBannedReply(addr) {
+				return
+			}
+			manager.Logger.Debug("connection banned", "address", addr.String())
+			banned := protocol.NewConnectionBanned()
+			banned.ServerId = manager.ServerId
+			banned.Encode()
+			manager.send(banned.Buffer, addr)
+		}
 		return
 	}
-	buffer = buffer[:n]
-	manager.Sessions.SessionExists(addr)
-
-	defer func() {
-		if err := recover(); err != nil {
-			manager.BlockIP(addr, time.Second * 5)
-			fmt.Println("IP blocked of", addr, "for 5 seconds:", err)
+	hasSession := manager.Sessions.SessionExists(addr)
+	if manager.RateLimiter != nil && n > 0 {
+		class := classifyPacket(buffer[0], hasSession)
+		if !manager.RateLimiter.Allow(addr.IP, class) {
+			manager.Logger.Warn("rate limit exceeded", "address", addr.String(), "class", class.String())
+			manager.counters.add(counterDropped, 1)
+			if manager.RateLimiter.BlockDuration > 0 {
+				manager.BlockIP(addr, manager.RateLimiter.BlockDuration)
+			}
+			manager.RateLimiter.LimitFunction(addr, class)
+			return
 		}
-	}()
-	packet := getPacketFor(buffer, manager.Sessions.SessionExists(addr))
+	}
+
+	packet, err := getPacketFor(buffer, hasSession, manager.Security)
+	if err != nil {
+		manager.counters.add(counterDropped, 1)
+		manager.handleMalformedPacket(addr, err)
+		return
+	}
 
 	if raw, ok := packet.(RawPacket); ok {
 		manager.RawPacketFunction(raw.Buffer, addr)
@@ -188,36 +531,79 @@ func (manager *Manager) processIncomingPacket() {
 		HandleUnconnectedMessage(packet, addr, manager)
 	} else {
 		session, ok := manager.Sessions.GetSession(addr)
-		if !ok {
+		if !ok || session.IsClosed() {
+			manager.counters.add(counterDropped, 1)
 			return
 		}
+		session.counters.add(counterBytesReceived, n)
 		if datagram, ok := packet.(*protocol.Datagram); ok {
-			session.ReceiveWindow.AddDatagram(datagram)
+			session.count(counterDatagramsReceived, 1)
+			if !session.ReceiveWindow.AddDatagram(datagram) {
+				session.count(counterDropped, 1)
+			}
 		} else if ack, ok := packet.(*protocol.ACK); ok {
+			session.count(counterACKsReceived, 1)
 			session.HandleACK(ack)
 		} else if nack, ok := packet.(*protocol.NAK); ok {
+			session.count(counterNAKsReceived, 1)
 			session.HandleNACK(nack)
 		}
 	}
 }
 
-// SessionManager is a manager of all sessions in the Manager.
-type SessionManager map[string]*Session
+// send sends the given buffer to the UDP address, without a session.
+// Unconnected replies should be sent using send, so that they are counted.
+func (manager *Manager) send(buffer []byte, addr *net.UDPAddr) (int, error) {
+	manager.counters.add(counterBytesSent, len(buffer))
+	return manager.Server.Write(buffer, addr)
+}
 
-// NewSessionManager returns a new session manager.
-func NewSessionManager() SessionManager {
-	return SessionManager{}
+// A managerLoop is a goroutine of the manager that runs until it gets halted.
+type managerLoop struct {
+	halt chan struct{}
+	done chan struct{}
+	once sync.Once
 }
 
-// SessionExists checks if the session manager has a session with a UDPAddr.
-func (manager SessionManager) SessionExists(addr *net.UDPAddr) bool {
-	_, ok := manager[fmt.Sprint(addr)]
-	return ok
+// startLoop starts a new loop running the given function.
+// The function should return once the halt channel passed gets closed.
+func startLoop(function func(halt <-chan struct{})) *managerLoop {
+	loop := &managerLoop{halt: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(loop.done)
+		function(loop.halt)
+	}()
+	return loop
+}
+
+// stop halts the loop without waiting for it to return.
+func (loop *managerLoop) stop() {
+	loop.once.Do(func() {
+		close(loop.halt)
+	})
 }
 
-// GetSession returns a session by a UDP address.
-// GetSession also returns a bool indicating success of the call.
-func (manager SessionManager) GetSession(addr *net.UDPAddr) (*Session, bool) {
-	session, ok := manager[fmt.Sprint(addr)]
-	return session, ok
-}
\ No newline at end of file
+// wait waits until the loop has returned, or until the context expires.
+func (loop *managerLoop) wait(ctx context.Context) error {
+	select {
+	case <-loop.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitUntil checks the condition every tick, until it is met or until the context expires.
+// The error of the context is returned if it expired.
+func waitUntil(ctx context.Context, condition func() bool) error {
+	ticker := time.NewTicker(time.Second / 80)
+	defer ticker.Stop()
+	for !condition() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
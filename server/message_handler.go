@@ -3,8 +3,6 @@ package server
 import (
 	"github.com/irmine/goraklib/protocol"
 	"net"
-	"time"
-	"fmt"
 )
 
 // HandleUnconnectedMessage handles an incoming unconnected message from a UDPAddr.
@@ -24,28 +22,66 @@ func HandleUnconnectedMessage(packetInterface protocol.IPacket, addr *net.UDPAdd
 // handleUnconnectedPing handles an unconnected ping.
 // An unconnected pong is sent back with the server's pong data.
 func handleUnconnectedPing(addr *net.UDPAddr, manager *Manager) {
+	manager.Logger.Debug("unconnected ping", "address", addr.String())
 	pong := protocol.NewUnconnectedPong()
-	pong.PingTime = time.Now().Unix()
+	pong.PingTime = manager.Clock.Now().Unix()
 	pong.ServerId = manager.ServerId
 	pong.PongData = manager.PongData
 	pong.Encode()
-	manager.Server.Write(pong.Buffer, addr)
+	manager.send(pong.Buffer, addr)
 }
 
 // handleOpenConnectionRequest1 handles an open connection request 1.
-// An open connection response 1 is sent back with the MTU size and security.
+// An open connection response 1 is sent back with the MTU size and security,
+// along with a security cookie if security is enabled.
+// An incompatible protocol version reply is sent instead if the protocol of the client is not supported.
 func handleOpenConnectionRequest1(request *protocol.OpenConnectionRequest1, addr *net.UDPAddr, manager *Manager) {
+	manager.Logger.Debug("open connection request 1", "address", addr.String(), "protocol", request.Protocol, "mtuSize", request.MtuSize)
+	if !manager.SupportsProtocol(request.Protocol) {
+		manager.Logger.Info("incompatible protocol version", "address", addr.String(), "protocol", request.Protocol)
+		manager.counters.add(counterHandshakeFailures, 1)
+		reply := protocol.NewIncompatibleProtocolVersion()
+		reply.Protocol = manager.SupportedProtocols[len(manager.SupportedProtocols)-1]
+		reply.ServerId = manager.ServerId
+		reply.Encode()
+		manager.send(reply.Buffer, addr)
+		return
+	}
 	reply := protocol.NewOpenConnectionReply1()
 	reply.ServerId = manager.ServerId
 	reply.MtuSize = request.MtuSize
 	reply.Security = manager.Security
+	if manager.Security {
+		reply.Cookie = manager.cookie(addr, manager.cookieInterval())
+	}
 	reply.Encode()
-	manager.Server.Write(reply.Buffer, addr)
+	manager.send(reply.Buffer, addr)
 }
 
 // handleOpenConnectionRequest2 handles an open connection request 2.
 // An open connection response 2 is sent back, with the definite MTU size and encryption.
+// Requests of addresses that already have a session get the reply sent again, without replacing the session.
+// Requests are ignored while the manager is shutting down or if their security cookie is invalid,
+// and a no free incoming connections reply is sent instead if the connection limits are reached.
 func handleOpenConnectionRequest2(request *protocol.OpenConnectionRequest2, addr *net.UDPAddr, manager *Manager) {
+	manager.Logger.Debug("open connection request 2", "address", addr.String(), "clientId", request.ClientId, "mtuSize", request.MtuSize)
+	if manager.shuttingDown.Load() {
+		return
+	}
+	if manager.Security && !manager.ValidCookie(addr, request.Cookie) {
+		manager.Logger.Debug("invalid security cookie", "address", addr.String())
+		manager.counters.add(counterHandshakeFailures, 1)
+		return
+	}
+	if !manager.HasFreeConnections(addr) {
+		manager.Logger.Info("no free incoming connections", "address", addr.String())
+		manager.counters.add(counterHandshakeFailures, 1)
+		reply := protocol.NewNoFreeIncomingConnections()
+		reply.ServerId = manager.ServerId
+		reply.Encode()
+		manager.send(reply.Buffer, addr)
+		return
+	}
 	reply := protocol.NewOpenConnectionReply2()
 	reply.ServerId = manager.ServerId
 	if request.MtuSize < MinimumMTUSize {
@@ -58,8 +94,12 @@ func handleOpenConnectionRequest2(request *protocol.OpenConnectionRequest2, addr
 	reply.ClientAddress = addr.IP.String()
 	reply.ClientPort = uint16(addr.Port)
 
+	if session, ok := manager.Sessions.GetSession(addr); ok {
+		// The reply to an earlier request got lost, so it is sent again for the existing session.
+		reply.MtuSize = session.MTUSize
+	} else {
+		manager.Sessions.AddSession(NewSession(addr, request.MtuSize, manager))
+	}
 	reply.Encode()
-
-	manager.Sessions[fmt.Sprint(addr)] = NewSession(addr, request.MtuSize, manager)
-	manager.Server.Write(reply.Buffer, addr)
+	manager.send(reply.Buffer, addr)
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"sync"
+)
+
+// MessageWindowSize is the amount of message indexes a MessageWindow tracks
+// beyond the lowest message index that has not yet been received.
+// Datagrams carrying reliable messages with a message index beyond the window are not acknowledged,
+// so that they get resent once the window slid forward.
+const MessageWindowSize = 8192
+
+// A MessageWindow tracks the message indexes of received reliable messages,
+// so that every reliable message is handled exactly once.
+// Resent and duplicated datagrams can carry messages that have already been received,
+// which are recognized by the window and should be dropped.
+// The window slides forward once all messages below an index have been received,
+// which keeps the memory used bounded by the MessageWindowSize.
+type MessageWindow struct {
+	sync.Mutex
+	start    uint32
+	received map[uint32]struct{}
+}
+
+// NewMessageWindow returns a new message window.
+func NewMessageWindow() *MessageWindow {
+	return &MessageWindow{sync.Mutex{}, 0, make(map[uint32]struct{})}
+}
+
+// Fits checks if a message with the given index can be received by the window,
+// which is the case for all messages below the end of the window, including those received before.
+func (window *MessageWindow) Fits(messageIndex uint32) bool {
+	messageIndex &= triadMask
+	window.Lock()
+	defer window.Unlock()
+	return isOlderIndex(messageIndex, window.start) || (messageIndex-window.start)&triadMask < MessageWindowSize
+}
+
+// Receive marks the given message index as received.
+// Receive returns true if the message has not been received before and should be handled,
+// and false if the message is a duplicate or lies beyond the window.
+func (window *MessageWindow) Receive(messageIndex uint32) bool {
+	messageIndex &= triadMask
+	window.Lock()
+	defer window.Unlock()
+
+	if isOlderIndex(messageIndex, window.start) {
+		return false
+	}
+	if (messageIndex-window.start)&triadMask >= MessageWindowSize {
+		return false
+	}
+	if _, ok := window.received[messageIndex]; ok {
+		return false
+	}
+	window.received[messageIndex] = struct{}{}
+
+	for {
+		if _, ok := window.received[window.start]; !ok {
+			return true
+		}
+		delete(window.received, window.start)
+		window.start = (window.start + 1) & triadMask
+	}
+}
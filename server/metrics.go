@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metric is a counter exported by the metrics handler.
+type metric struct {
+	name  string
+	help  string
+	value func(counters Counters) uint64
+}
+
+// counterMetrics are the metrics of the counters of both the manager and its sessions.
+// Metrics of sessions are prefixed with "session_".
+var counterMetrics = []metric{
+	{"datagrams_received_total", "Datagrams received.", func(counters Counters) uint64 { return counters.DatagramsReceived }},
+	{"datagrams_sent_total", "Datagrams sent, including retransmissions.", func(counters Counters) uint64 { return counters.DatagramsSent }},
+	{"bytes_received_total", "Bytes received.", func(counters Counters) uint64 { return counters.BytesReceived }},
+	{"bytes_sent_total", "Bytes sent.", func(counters Counters) uint64 { return counters.BytesSent }},
+	{"acks_received_total", "ACK packets received.", func(counters Counters) uint64 { return counters.ACKsReceived }},
+	{"acks_sent_total", "ACK packets sent.", func(counters Counters) uint64 { return counters.ACKsSent }},
+	{"naks_received_total", "NAK packets received.", func(counters Counters) uint64 { return counters.NAKsReceived }},
+	{"naks_sent_total", "NAK packets sent.", func(counters Counters) uint64 { return counters.NAKsSent }},
+	{"retransmissions_total", "Datagrams retransmitted.", func(counters Counters) uint64 { return counters.Retransmissions }},
+	{"split_reassemblies_total", "Split packets reassembled.", func(counters Counters) uint64 { return counters.SplitReassemblies }},
+	{"dropped_packets_total", "Packets dropped.", func(counters Counters) uint64 { return counters.Dropped }},
+}
+
+// labelEscaper escapes label values of the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler returns an HTTP handler that serves the statistics of the manager in the Prometheus text format.
+// Metrics are prefixed with "goraklib_". Metrics of sessions are aggregated over all sessions, and are only exported
+// per session for at most MetricsMaxSessions sessions, labelled with the address and client ID of the session.
+func (manager *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buffer := bufio.NewWriter(writer)
+		writeMetrics(buffer, manager.Stats(), manager.MetricsMaxSessions)
+		buffer.Flush()
+	})
+}
+
+// writeMetrics writes the statistics in the Prometheus text format,
+// including the series of at most maxSessions sessions.
+func writeMetrics(writer *bufio.Writer, stats Stats, maxSessions int) {
+	for _, metric := range counterMetrics {
+		writeHeader(writer, metric.name, metric.help, "counter")
+		fmt.Fprintf(writer, "goraklib_%v %v\n", metric.name, metric.value(stats.Counters))
+	}
+	writeHeader(writer, "handshake_failures_total", "Open connection requests rejected.", "counter")
+	fmt.Fprintf(writer, "goraklib_handshake_failures_total %v\n", stats.HandshakeFailures)
+	writeHeader(writer, "sessions", "Sessions currently open.", "gauge")
+	fmt.Fprintf(writer, "goraklib_sessions %v\n", len(stats.Sessions))
+	writeHeader(writer, "blocked_ips", "IP addresses currently blocked.", "gauge")
+	fmt.Fprintf(writer, "goraklib_blocked_ips %v\n", stats.BlockedIPs)
+
+	var queueDepths [4]int
+	bytesInFlight := 0
+	var rttSum, rttMax time.Duration
+	rttSessions := 0
+	for _, session := range stats.Sessions {
+		for priority, depth := range session.QueueDepths {
+			queueDepths[priority] += depth
+		}
+		bytesInFlight += session.BytesInFlight
+		// Sessions without RTT samples have no round trip time yet, so they are left out.
+		if session.RTT.Samples == 0 {
+			continue
+		}
+		rttSum += session.RTT.Smoothed
+		if session.RTT.Smoothed > rttMax {
+			rttMax = session.RTT.Smoothed
+		}
+		rttSessions++
+	}
+	rttMean := time.Duration(0)
+	if rttSessions > 0 {
+		rttMean = rttSum / time.Duration(rttSessions)
+	}
+	writeHeader(writer, "queue_depth", "Encapsulated packets in the priority queues of all sessions.", "gauge")
+	for priority, depth := range queueDepths {
+		fmt.Fprintf(writer, "goraklib_queue_depth{priority=\"%v\"} %v\n", Priority(priority), depth)
+	}
+	writeHeader(writer, "bytes_in_flight", "Bytes sent by all sessions that have not yet been acknowledged.", "gauge")
+	fmt.Fprintf(writer, "goraklib_bytes_in_flight %v\n", bytesInFlight)
+	writeHeader(writer, "rtt_seconds", "Smoothed round trip time of all sessions, by mean and maximum.", "gauge")
+	fmt.Fprintf(writer, "goraklib_rtt_seconds{stat=\"mean\"} %v\n", rttMean.Seconds())
+	fmt.Fprintf(writer, "goraklib_rtt_seconds{stat=\"max\"} %v\n", rttMax.Seconds())
+
+	if maxSessions <= 0 {
+		return
+	}
+	// Sessions are sorted by address, so that the same sessions are exported every scrape.
+	sort.Slice(stats.Sessions, func(i, j int) bool {
+		return stats.Sessions[i].Address < stats.Sessions[j].Address
+	})
+	if len(stats.Sessions) > maxSessions {
+		stats.Sessions = stats.Sessions[:maxSessions]
+	}
+	labels := make([]string, len(stats.Sessions))
+	for i, session := range stats.Sessions {
+		labels[i] = fmt.Sprintf(`address="%v",client_id="%v"`, labelEscaper.Replace(session.Address), session.ClientId)
+	}
+	for _, metric := range counterMetrics {
+		writeHeader(writer, "session_"+metric.name, metric.help, "counter")
+		for i, session := range stats.Sessions {
+			fmt.Fprintf(writer, "goraklib_session_%v{%v} %v\n", metric.name, labels[i], metric.value(session.Counters))
+		}
+	}
+	writeHeader(writer, "session_rtt_seconds", "Smoothed round trip time.", "gauge")
+	for i, session := range stats.Sessions {
+		fmt.Fprintf(writer, "goraklib_session_rtt_seconds{%v} %v\n", labels[i], session.RTT.Smoothed.Seconds())
+	}
+	writeHeader(writer, "session_rtt_variance_seconds", "Round trip time variance.", "gauge")
+	for i, session := range stats.Sessions {
+		fmt.Fprintf(writer, "goraklib_session_rtt_variance_seconds{%v} %v\n", labels[i], session.RTT.Variance.Seconds())
+	}
+	writeHeader(writer, "session_queue_depth", "Encapsulated packets in the priority queue.", "gauge")
+	for i, session := range stats.Sessions {
+		for priority, depth := range session.QueueDepths {
+			fmt.Fprintf(writer, "goraklib_session_queue_depth{%v,priority=\"%v\"} %v\n", labels[i], Priority(priority), depth)
+		}
+	}
+	writeHeader(writer, "session_bytes_in_flight", "Bytes sent that have not yet been acknowledged.", "gauge")
+	for i, session := range stats.Sessions {
+		fmt.Fprintf(writer, "goraklib_session_bytes_in_flight{%v} %v\n", labels[i], session.BytesInFlight)
+	}
+	writeHeader(writer, "session_congestion_window_bytes", "Congestion window.", "gauge")
+	for i, session := range stats.Sessions {
+		fmt.Fprintf(writer, "goraklib_session_congestion_window_bytes{%v} %v\n", labels[i], session.CongestionWindow)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func writeHeader(writer *bufio.Writer, name, help, metricType string) {
+	fmt.Fprintf(writer, "# HELP goraklib_%v %v\n# TYPE goraklib_%v %v\n", name, help, name, metricType)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/irmine/goraklib/protocol"
+)
+
+const (
+	// OrderChannelCount is the amount of ordering channels available.
+	// Order channels are numbered from 0 up to and including 31.
+	OrderChannelCount = 32
+	// MaximumOrderWindow is the maximum distance between the expected order index of a channel
+	// and the order index of a packet held in the channel.
+	// Packets further ahead than this are dropped, which limits the memory a channel can use.
+	MaximumOrderWindow = 4096
+)
+
+// triadMask is used to wrap order and sequence indexes, which are sent as triads.
+const triadMask = 0xffffff
+
+// An OrderQueue restores the order of ordered and sequenced encapsulated packets.
+// Every order channel is ordered independently from other channels.
+// Ordered packets that arrive out of order are held until all preceding packets have arrived,
+// while sequenced packets older than the newest sequenced packet received are dropped.
+type OrderQueue struct {
+	channels [OrderChannelCount]*orderChannel
+}
+
+// orderChannel is a single ordering channel of an OrderQueue.
+// The mutex of the channel is held while packets get released,
+// so that packets of one channel are always released one after another.
+type orderChannel struct {
+	sync.Mutex
+	pending              map[uint32][]*protocol.EncapsulatedPacket
+	expectedOrderIndex   uint32
+	highestSequenceIndex uint32
+}
+
+// NewOrderQueue returns a new order queue with all order channels.
+func NewOrderQueue() *OrderQueue {
+	queue := &OrderQueue{}
+	for i := range queue.channels {
+		queue.channels[i] = &orderChannel{pending: make(map[uint32][]*protocol.EncapsulatedPacket)}
+	}
+	return queue
+}
+
+// Order adds an ordered or sequenced encapsulated packet to its order channel.
+// The release function gets called for the packet, and any packets held in the channel,
+// once all packets preceding them have arrived.
+// Packets of the same channel are released one after another, in order,
+// and the release function is called while the channel is locked.
+func (queue *OrderQueue) Order(packet *protocol.EncapsulatedPacket, release func(packet *protocol.EncapsulatedPacket)) {
+	if packet.OrderChannel >= OrderChannelCount {
+		return
+	}
+	channel := queue.channels[packet.OrderChannel]
+	channel.Lock()
+	defer channel.Unlock()
+
+	orderIndex := packet.OrderIndex & triadMask
+	if orderIndex != channel.expectedOrderIndex {
+		if isOlderIndex(orderIndex, channel.expectedOrderIndex) {
+			return
+		}
+		if (orderIndex-channel.expectedOrderIndex)&triadMask > MaximumOrderWindow {
+			return
+		}
+		channel.pending[orderIndex] = append(channel.pending[orderIndex], packet)
+		return
+	}
+	channel.releasePacket(packet, release)
+
+	for {
+		packets, ok := channel.pending[channel.expectedOrderIndex]
+		if !ok {
+			return
+		}
+		delete(channel.pending, channel.expectedOrderIndex)
+
+		// Sequenced packets are released before the ordered packet with the same order index,
+		// as they were sent before that ordered packet.
+		sort.SliceStable(packets, func(i, j int) bool {
+			return packets[i].IsSequenced() && !packets[j].IsSequenced()
+		})
+		for _, pk := range packets {
+			channel.releasePacket(pk, release)
+		}
+	}
+}
+
+// releasePacket releases a packet with the order index currently expected.
+// Sequenced packets older than the newest sequenced packet are dropped,
+// and ordered packets advance the channel to the next order index.
+func (channel *orderChannel) releasePacket(packet *protocol.EncapsulatedPacket, release func(packet *protocol.EncapsulatedPacket)) {
+	if packet.IsSequenced() {
+		sequenceIndex := packet.SequenceIndex & triadMask
+		if isOlderIndex(sequenceIndex, channel.highestSequenceIndex) {
+			return
+		}
+		channel.highestSequenceIndex = (sequenceIndex + 1) & triadMask
+		release(packet)
+		return
+	}
+	if packet.OrderIndex&triadMask != channel.expectedOrderIndex {
+		return
+	}
+	channel.expectedOrderIndex = (channel.expectedOrderIndex + 1) & triadMask
+	channel.highestSequenceIndex = 0
+	release(packet)
+}
+
+// isOlderIndex checks if the triad index a comes before the triad index b,
+// taking wrapping of the index into account.
+func isOlderIndex(a uint32, b uint32) bool {
+	return a != b && (b-a)&triadMask < triadMask/2
+}
@@ -3,8 +3,13 @@ package server
 import "github.com/irmine/goraklib/protocol"
 
 // GetPacketFor selects the appropriate packet by a buffer.
-// It uses hasSession to check for appropriate messages.
-func getPacketFor(buffer []byte, hasSession bool) protocol.IPacket {
+// It uses hasSession to check for appropriate messages,
+// and security to check if open connection requests hold a security cookie.
+// An error is returned if the buffer is empty or the packet could not be decoded.
+func getPacketFor(buffer []byte, hasSession bool, security bool) (protocol.IPacket, error) {
+	if len(buffer) == 0 {
+		return nil, protocol.ErrShortBuffer
+	}
 	header := buffer[0]
 	var packet protocol.IPacket
 	if hasSession {
@@ -15,6 +20,11 @@ func getPacketFor(buffer []byte, hasSession bool) protocol.IPacket {
 			packet = protocol.NewNAK()
 		case header & protocol.BitFlagValid != 0:
 			packet = protocol.NewDatagram()
+		case header == protocol.IdOpenConnectionRequest2:
+			// The client retries the request if the reply to it got lost.
+			request := protocol.NewOpenConnectionRequest2()
+			request.Security = security
+			packet = request
 		}
 	} else {
 		switch header {
@@ -23,13 +33,17 @@ func getPacketFor(buffer []byte, hasSession bool) protocol.IPacket {
 		case protocol.IdOpenConnectionRequest1:
 			packet = protocol.NewOpenConnectionRequest1()
 		case protocol.IdOpenConnectionRequest2:
-			packet = protocol.NewOpenConnectionRequest2()
+			request := protocol.NewOpenConnectionRequest2()
+			request.Security = security
+			packet = request
 		}
 	}
 	if packet == nil {
 		packet = NewRawPacket()
 	}
 	packet.SetBuffer(buffer)
-	packet.Decode()
-	return packet
+	if err := packet.Decode(); err != nil {
+		return nil, err
+	}
+	return packet, nil
 }
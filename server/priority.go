@@ -24,6 +24,21 @@ const (
 // PriorityImmediate will make packets get sent out immediately.
 type Priority byte
 
+// String returns a human readable name of the priority.
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityImmediate:
+		return "immediate"
+	case PriorityHigh:
+		return "high"
+	case PriorityMedium:
+		return "medium"
+	case PriorityLow:
+		return "low"
+	}
+	return "unknown"
+}
+
 // A PriorityQueue is used to send packets with a certain priority.
 // Encapsulated packets can be queued in these queues.
 type PriorityQueue chan *protocol.EncapsulatedPacket
@@ -39,10 +54,25 @@ func NewPriorityQueue(bufferingSize int) *PriorityQueue {
 // AddEncapsulated adds an encapsulated packet to a priority queue.
 // The packet will first be split into smaller sub packets if needed,
 // after which all packets will be added to the queue.
+// AddEncapsulated blocks while the queue is full, until the session is closed.
 func (queue *PriorityQueue) AddEncapsulated(packet *protocol.EncapsulatedPacket, session *Session) {
 	for _, encapsulated := range queue.Split(packet, session) {
-		*queue <- encapsulated
+		select {
+		case *queue <- encapsulated:
+		case <-session.done:
+			return
+		}
+	}
+}
+
+// hasRoom checks if the queue has room for all sub packets of a packet of the given length, once split for the session.
+// Packets split into more sub packets than the queue can hold have room once the queue is empty.
+func (queue *PriorityQueue) hasRoom(length int, session *Session) bool {
+	count := int(math.Ceil(float64(length) / float64(session.MTUSize-60)))
+	if count > cap(*queue) {
+		count = cap(*queue)
 	}
+	return cap(*queue)-len(*queue) >= count
 }
 
 // Flush flushes all encapsulated packets in the priority queue, and sends them to a session.
@@ -50,27 +80,36 @@ func (queue *PriorityQueue) AddEncapsulated(packet *protocol.EncapsulatedPacket,
 // after which they will be put into datagrams.
 // A new datagram is made once an encapsulated packet makes the size
 // of a datagram exceed the MTU size of the session.
+// Packets stop being fetched once the bytes in flight reach the congestion window of the session,
+// and any packets left over remain in the queue until the next flush.
 func (queue *PriorityQueue) Flush(session *Session) {
-	if len(*queue) == 0 {
+	queue.flush(session, true)
+}
+
+// flush flushes the priority queue, either limited by the congestion window or not.
+func (queue *PriorityQueue) flush(session *Session, limited bool) {
+	budget := int(^uint(0) >> 1)
+	if limited {
+		budget = session.CongestionController.Window() - session.RecoveryQueue.BytesInFlight()
+	}
+	if len(*queue) == 0 || budget <= 0 {
 		return
 	}
 	ind := 0
 	datagram := protocol.NewDatagram()
 	datagram.NeedsBAndAs = true
 	datagrams := map[int]*protocol.Datagram{0: datagram}
-	datagram.SequenceNumber = session.Indexes.sendSequence
-	session.Indexes.sendSequence++
+	datagram.SequenceNumber = session.Indexes.NextSendSequence()
 
-	i := 0
-	for len(*queue) > 0 && i < 16 {
-		i++
+	size := 0
+	for len(*queue) > 0 && size < budget {
 		packet := <-*queue
+		size += packet.GetLength()
 		if datagrams[ind].GetLength()+packet.GetLength() > int(session.MTUSize-38) {
 			ind++
 			datagrams[ind] = protocol.NewDatagram()
 			datagrams[ind].NeedsBAndAs = true
-			datagrams[ind].SequenceNumber = session.Indexes.sendSequence
-			session.Indexes.sendSequence++
+			datagrams[ind].SequenceNumber = session.Indexes.NextSendSequence()
 		}
 		datagrams[ind].AddPacket(packet)
 	}
@@ -80,29 +119,37 @@ func (queue *PriorityQueue) Flush(session *Session) {
 		datagram := datagrams[j]
 		datagram.Encode()
 		session.RecoveryQueue.AddRecovery(datagram)
-		session.Manager.Server.Write(datagram.Buffer, session.UDPAddr)
+		session.count(counterDatagramsSent, 1)
+		session.Send(datagram.Buffer)
 	}
 }
 
 // Split splits an encapsulated packet into smaller sub packets.
 // Every encapsulated packet that exceeds the MTUSize of the session
 // will be split into sub packets, and returned into a slice.
+// The indexes of the packets are assigned while the indexes of the session are locked,
+// so that packets may be split by multiple goroutines at once.
 func (queue *PriorityQueue) Split(packet *protocol.EncapsulatedPacket, session *Session) []*protocol.EncapsulatedPacket {
 	mtuSize := int(session.MTUSize - 60) // We subtract 60 to account for headers.
 	var packets []*protocol.EncapsulatedPacket
+	session.Indexes.Lock()
+	defer session.Indexes.Unlock()
 
+	channel := packet.OrderChannel
 	if packet.IsOrdered() {
-		packet.OrderIndex = session.Indexes.orderIndex
-		session.Indexes.orderIndex++
-	}else if packet.IsSequenced() {
-		packet.SequenceIndex = session.Indexes.sequenceIndex
-		session.Indexes.sequenceIndex++
-		packet.OrderIndex = session.Indexes.orderIndex
+		packet.OrderIndex = session.Indexes.orderIndex[channel]
+		session.Indexes.orderIndex[channel]++
+		session.Indexes.sequenceIndex[channel] = 0
+	} else if packet.IsSequenced() {
+		packet.SequenceIndex = session.Indexes.sequenceIndex[channel]
+		session.Indexes.sequenceIndex[channel]++
+		packet.OrderIndex = session.Indexes.orderIndex[channel]
 	}
 
 	if packet.GetLength() > mtuSize {
 		buffer := packet.GetBuffer()
 		splitSize := mtuSize
+		splitCount := uint(math.Ceil(float64(len(buffer)) / float64(splitSize)))
 		var b uint
 		for i := 0; i < len(buffer)+splitSize; i += splitSize {
 			if i + splitSize >= len(buffer) {
@@ -116,11 +163,13 @@ func (queue *PriorityQueue) Split(packet *protocol.EncapsulatedPacket, session *
 			encapsulated.HasSplit = true
 			encapsulated.SplitId = session.Indexes.splitId
 			encapsulated.SplitIndex = b
-			encapsulated.SplitCount = uint(math.Ceil(float64(len(buffer)) / float64(splitSize)))
+			encapsulated.SplitCount = splitCount
 			encapsulated.Reliability = packet.Reliability
 			b++
 			encapsulated.Buffer = split
 			encapsulated.OrderIndex = packet.OrderIndex
+			encapsulated.OrderChannel = packet.OrderChannel
+			encapsulated.SequenceIndex = packet.SequenceIndex
 			if packet.IsReliable() {
 				encapsulated.MessageIndex = session.Indexes.messageIndex
 				session.Indexes.messageIndex++
@@ -128,6 +177,7 @@ func (queue *PriorityQueue) Split(packet *protocol.EncapsulatedPacket, session *
 			packets = append(packets, encapsulated)
 		}
 		session.Indexes.splitId++
+		session.Receipts.Split(packet, packets)
 	} else {
 		if packet.IsReliable() {
 			packet.MessageIndex = session.Indexes.messageIndex
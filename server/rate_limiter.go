@@ -0,0 +1,183 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/irmine/goraklib/protocol"
+)
+
+const (
+	// PacketClassUnconnected is the class of unconnected pings,
+	// and of any other packet sent without a session that is not part of the handshake.
+	PacketClassUnconnected PacketClass = iota
+	// PacketClassHandshake is the class of open connection requests.
+	PacketClassHandshake
+	// PacketClassDatagram is the class of datagrams, ACKs and NAKs sent by sessions.
+	PacketClassDatagram
+)
+
+// DefaultRateLimitBlockDuration is the default duration addresses are blocked for once they exceed a rate limit.
+const DefaultRateLimitBlockDuration = time.Second * 10
+
+// PacketClass is the class of a packet, which decides the rate limit that applies to it.
+type PacketClass byte
+
+// String returns a human readable name of the packet class.
+func (class PacketClass) String() string {
+	switch class {
+	case PacketClassUnconnected:
+		return "unconnected"
+	case PacketClassHandshake:
+		return "handshake"
+	case PacketClassDatagram:
+		return "datagram"
+	}
+	return "unknown"
+}
+
+// RateLimit is the limit of a token bucket.
+// The bucket holds at most Burst packets, and refills at Rate packets per second.
+// A rate limit with a burst of 0 does not limit any packets.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// RateLimiterStats is a snapshot of the counters of a rate limiter.
+type RateLimiterStats struct {
+	// Allowed is the amount of packets that were within their rate limit.
+	Allowed uint64
+	// Limited is the amount of packets that exceeded their rate limit.
+	Limited uint64
+	// Addresses is the amount of IP addresses currently tracked.
+	Addresses int
+}
+
+// A RateLimiter limits the amount of packets of every IP address, using a token bucket per packet class.
+// Addresses exceeding any of the limits get blocked by the manager for the BlockDuration.
+type RateLimiter struct {
+	// Limits holds the rate limit of every packet class, indexed by the class.
+	Limits [3]RateLimit
+	// BlockDuration is the duration addresses get blocked for once they exceed a rate limit.
+	// Packets exceeding the rate limit are dropped without blocking the address if the duration is 0.
+	BlockDuration time.Duration
+	// LimitFunction gets called with the address and packet class for every packet exceeding a rate limit.
+	// Addresses blocked are ignored completely, so that it gets called once per block.
+	LimitFunction func(addr *net.UDPAddr, class PacketClass)
+	// Clock is the clock the buckets are refilled with.
+	// The clock of the rate limiter of a manager is set to the clock of the manager once the manager runs.
+	Clock Clock
+
+	sync.Mutex
+	buckets map[string]*[3]tokenBucket
+	allowed uint64
+	limited uint64
+}
+
+// tokenBucket is a token bucket of a single IP address and packet class.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new rate limiter with default limits.
+// The default limits allow pings of the server list and handshakes of clients behind a shared IP address,
+// and datagrams at a couple of megabytes per second.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		Limits: [3]RateLimit{
+			PacketClassUnconnected: {Rate: 20, Burst: 40},
+			PacketClassHandshake:   {Rate: 20, Burst: 40},
+			PacketClassDatagram:    {Rate: 2500, Burst: 5000},
+		},
+		BlockDuration: DefaultRateLimitBlockDuration,
+		LimitFunction: func(addr *net.UDPAddr, class PacketClass) {},
+		Clock:         SystemClock{},
+		buckets:       make(map[string]*[3]tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket of the IP address for the packet class,
+// and returns false if the bucket of the address was empty.
+func (limiter *RateLimiter) Allow(ip net.IP, class PacketClass) bool {
+	limit := limiter.Limits[class]
+	if limit.Burst <= 0 {
+		atomic.AddUint64(&limiter.allowed, 1)
+		return true
+	}
+	key := ipKey(ip)
+	now := limiter.Clock.Now()
+
+	limiter.Lock()
+	buckets, ok := limiter.buckets[key]
+	if !ok {
+		buckets = &[3]tokenBucket{}
+		limiter.buckets[key] = buckets
+	}
+	bucket := &buckets[class]
+	if bucket.last.IsZero() {
+		bucket.tokens = limit.Burst
+	} else {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * limit.Rate
+		if bucket.tokens > limit.Burst {
+			bucket.tokens = limit.Burst
+		}
+	}
+	bucket.last = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	limiter.Unlock()
+
+	if allowed {
+		atomic.AddUint64(&limiter.allowed, 1)
+	} else {
+		atomic.AddUint64(&limiter.limited, 1)
+	}
+	return allowed
+}
+
+// Prune removes the buckets of all IP addresses of which every bucket has refilled completely.
+// Those addresses are no longer limited, so that their buckets do not need to be kept.
+func (limiter *RateLimiter) Prune() {
+	now := limiter.Clock.Now()
+	limiter.Lock()
+	defer limiter.Unlock()
+	for key, buckets := range limiter.buckets {
+		full := true
+		for class, bucket := range buckets {
+			limit := limiter.Limits[class]
+			if !bucket.last.IsZero() && bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate < limit.Burst {
+				full = false
+				break
+			}
+		}
+		if full {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters of the rate limiter.
+func (limiter *RateLimiter) Stats() RateLimiterStats {
+	limiter.Lock()
+	addresses := len(limiter.buckets)
+	limiter.Unlock()
+	return RateLimiterStats{atomic.LoadUint64(&limiter.allowed), atomic.LoadUint64(&limiter.limited), addresses}
+}
+
+// classifyPacket returns the packet class of a packet with the given header.
+// All packets of addresses with a session are datagrams.
+func classifyPacket(header byte, hasSession bool) PacketClass {
+	if hasSession {
+		return PacketClassDatagram
+	}
+	if header == protocol.IdOpenConnectionRequest1 || header == protocol.IdOpenConnectionRequest2 {
+		return PacketClassHandshake
+	}
+	return PacketClassUnconnected
+}
@@ -19,7 +19,7 @@ func NewRawPacket() RawPacket {
 
 func (pk RawPacket) Encode() {}
 
-func (pk RawPacket) Decode() {}
+func (pk RawPacket) Decode() error {return nil}
 
 func (pk RawPacket) GetId() int {return -1}
 
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/irmine/goraklib/protocol"
+)
+
+// A Receipt is a delivery receipt for a packet sent with one of the WithAck reliabilities.
+// The receipt is done once every datagram carrying the packet, including all split parts, has been ACKed,
+// or once the packet is considered lost.
+type Receipt struct {
+	// Id is the ID of the receipt, which is unique within its session.
+	Id uint32
+
+	done         chan struct{}
+	acknowledged bool
+	pending      int
+	packets      []*protocol.EncapsulatedPacket
+}
+
+// Done returns a channel that gets closed once the receipt is done.
+// Acknowledged may be used to check the result once the channel is closed.
+func (receipt *Receipt) Done() <-chan struct{} {
+	return receipt.done
+}
+
+// Acknowledged checks if the packet of the receipt has been acknowledged by the other end.
+// Acknowledged returns false as long as the receipt is not done.
+func (receipt *Receipt) Acknowledged() bool {
+	select {
+	case <-receipt.done:
+		return receipt.acknowledged
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the receipt is done,
+// and returns true if the packet was acknowledged, or false if it was lost.
+func (receipt *Receipt) Wait() bool {
+	<-receipt.done
+	return receipt.acknowledged
+}
+
+// A ReceiptTracker tracks the delivery of packets sent with one of the WithAck reliabilities.
+// Every encapsulated packet is mapped to the receipt of the packet it is part of.
+type ReceiptTracker struct {
+	sync.Mutex
+	receipts map[*protocol.EncapsulatedPacket]*Receipt
+	nextId   uint32
+	closed   bool
+}
+
+// NewReceiptTracker returns a new receipt tracker.
+func NewReceiptTracker() *ReceiptTracker {
+	return &ReceiptTracker{receipts: make(map[*protocol.EncapsulatedPacket]*Receipt)}
+}
+
+// Track starts tracking the delivery of an encapsulated packet, and returns its receipt.
+// The receipt returned is immediately lost if all receipts of the tracker have already been lost.
+func (tracker *ReceiptTracker) Track(packet *protocol.EncapsulatedPacket) *Receipt {
+	tracker.Lock()
+	defer tracker.Unlock()
+	receipt := &Receipt{Id: tracker.nextId, done: make(chan struct{}), pending: 1, packets: []*protocol.EncapsulatedPacket{packet}}
+	tracker.nextId++
+	if tracker.closed {
+		close(receipt.done)
+		return receipt
+	}
+	tracker.receipts[packet] = receipt
+	return receipt
+}
+
+// Split replaces a tracked encapsulated packet with the split packets it was split into.
+// The receipt of the packet is done once all of the split packets have been acknowledged.
+func (tracker *ReceiptTracker) Split(packet *protocol.EncapsulatedPacket, splits []*protocol.EncapsulatedPacket) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	receipt, ok := tracker.receipts[packet]
+	if !ok {
+		return
+	}
+	delete(tracker.receipts, packet)
+	receipt.pending = len(splits)
+	receipt.packets = splits
+	for _, split := range splits {
+		tracker.receipts[split] = receipt
+	}
+}
+
+// Acknowledge marks all tracked packets in the datagram as acknowledged.
+// Receipts of which all packets have been acknowledged are done.
+func (tracker *ReceiptTracker) Acknowledge(datagram *protocol.Datagram) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	for _, packet := range *datagram.GetPackets() {
+		receipt, ok := tracker.receipts[packet]
+		if !ok {
+			continue
+		}
+		delete(tracker.receipts, packet)
+		receipt.pending--
+		if receipt.pending == 0 {
+			receipt.acknowledged = true
+			close(receipt.done)
+		}
+	}
+}
+
+// Lose marks all tracked packets in the datagram as lost.
+// The receipts of those packets are done, and are no longer tracked.
+func (tracker *ReceiptTracker) Lose(datagram *protocol.Datagram) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	for _, packet := range *datagram.GetPackets() {
+		if receipt, ok := tracker.receipts[packet]; ok {
+			tracker.lose(receipt)
+		}
+	}
+}
+
+// LoseAll marks all tracked packets as lost.
+// LoseAll is used once the session closes, as no more packets can be acknowledged then.
+// Packets tracked after calling LoseAll are lost immediately.
+func (tracker *ReceiptTracker) LoseAll() {
+	tracker.Lock()
+	defer tracker.Unlock()
+	tracker.closed = true
+	for _, receipt := range tracker.receipts {
+		tracker.lose(receipt)
+	}
+}
+
+// lose completes a receipt as lost, and stops tracking all of its packets.
+// The tracker must be locked while calling lose.
+func (tracker *ReceiptTracker) lose(receipt *Receipt) {
+	for _, packet := range receipt.packets {
+		delete(tracker.receipts, packet)
+	}
+	close(receipt.done)
+}
@@ -2,11 +2,24 @@ package server
 
 import (
 	"github.com/irmine/goraklib/protocol"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// ReceiveWindowSize is the maximum distance between the expected sequence number
+	// and the sequence number of a datagram held in the receive window.
+	// Datagrams further ahead than this are dropped.
+	ReceiveWindowSize = 2048
+	// DefaultNAKInterval is the default minimum duration between two NAKs for the same sequence number.
+	DefaultNAKInterval = time.Millisecond * 100
+	// DefaultSkipDelay is the default duration after which a missing datagram is considered permanently lost.
+	DefaultSkipDelay = time.Second
+)
+
 // TimestampedDatagram is a datagram encapsulated by a timestamp.
 // Every datagram added to the receive window gets its timestamp recorded immediately.
+// The timestamp is the time of receiving in milliseconds.
 type TimestampedDatagram struct {
 	*protocol.Datagram
 	Timestamp int64
@@ -14,50 +27,160 @@ type TimestampedDatagram struct {
 
 // ReceiveWindow is a window used to hold datagrams until they're read to be released.
 // ReceiveWindow restores the order of datagrams that arrived out of order,
-// and sends NAKs where needed.
+// and sends ACKs and NAKs where needed.
+// ACKs are batched: all datagrams received between two ticks are acknowledged at once.
 type ReceiveWindow struct {
 	// DatagramHandleFunction is a function that gets called once a datagram gets released from the receive window.
 	// A timestamped datagram gets returned with the timestamp of the time the datagram entered the receive window.
 	DatagramHandleFunction func(datagram TimestampedDatagram)
+	// ACKFunction is a function that gets called every tick with the sequence numbers of all datagrams received.
+	// The sequence numbers should be sent to the other end in a single ACK.
+	ACKFunction func(sequenceNumbers []uint32)
+	// NAKFunction is a function that gets called with the sequence numbers of missing datagrams.
+	// The sequence numbers should be sent to the other end in a NAK, so that the datagrams get resent.
+	NAKFunction func(sequenceNumbers []uint32)
+	// AcceptFunction is a function that gets called for every new datagram before it gets acknowledged.
+	// Datagrams for which it returns false are neither acknowledged nor handled, so that the other end resends them
+	// once their retransmission timeout runs out. They are not NAKed either, as resending them immediately would be refused too.
+	AcceptFunction func(datagram *protocol.Datagram) bool
+	// NAKInterval is the minimum duration between two NAKs for the same missing datagram.
+	NAKInterval time.Duration
+	// SkipDelay is the duration after which a missing datagram is considered permanently lost.
+	// Lost datagrams are skipped, so that datagrams after it can be released.
+	SkipDelay time.Duration
+	// Clock is the clock datagrams are timestamped with, and missing datagrams are timed with.
+	Clock Clock
+	// Synchronous makes datagrams released get handled on the goroutine calling Tick,
+	// instead of each on a goroutine of its own.
+	Synchronous bool
 
 	pendingDatagrams       chan TimestampedDatagram
 	datagrams              map[uint32]TimestampedDatagram
+	missingDatagrams       map[uint32]*missingDatagram
 	expectedSequenceNumber uint32
 	highestSequenceNumber  uint32
+	handling               int32
+}
+
+// missingDatagram holds the time a datagram was first found missing,
+// and the time a NAK was last sent for it.
+type missingDatagram struct {
+	detected time.Time
+	nakSent  time.Time
 }
 
 // NewReceiveWindow returns a new receive window.
 func NewReceiveWindow() *ReceiveWindow {
-	return &ReceiveWindow{func(datagram TimestampedDatagram){}, make(chan TimestampedDatagram, 128), make(map[uint32]TimestampedDatagram), 0, 0}
+	return &ReceiveWindow{func(datagram TimestampedDatagram){}, func(sequenceNumbers []uint32){}, func(sequenceNumbers []uint32){}, func(datagram *protocol.Datagram) bool { return true }, DefaultNAKInterval, DefaultSkipDelay, SystemClock{}, false,
+		make(chan TimestampedDatagram, 128), make(map[uint32]TimestampedDatagram), make(map[uint32]*missingDatagram), 0, 0, 0}
 }
 
 // AddDatagram adds a datagram to the receive window.
 // The datagram is first encapsulated with a timestamp,
 // and is added to a channel in order to await the next tick for further processing.
-func (window *ReceiveWindow) AddDatagram(datagram *protocol.Datagram) {
-	if datagram.SequenceNumber < window.expectedSequenceNumber {
-		return
+// AddDatagram never blocks: the datagram is dropped and false is returned if the channel is full,
+// after which the datagram is not acknowledged and gets resent by the other end.
+func (window *ReceiveWindow) AddDatagram(datagram *protocol.Datagram) bool {
+	select {
+	case window.pendingDatagrams <- TimestampedDatagram{datagram, millis(window.Clock.Now())}:
+		return true
+	default:
+		return false
 	}
-	if datagram.SequenceNumber > window.highestSequenceNumber {
-		window.highestSequenceNumber = datagram.SequenceNumber
-	}
-	window.pendingDatagrams <- TimestampedDatagram{datagram, time.Now().Unix()}
 }
 
 // Tick ticks the ReceiveWindow and releases any datagrams when possible.
-// Tick also fetches all datagrams that are currently in the channel.
+// Tick also fetches all datagrams that are currently in the channel, and acknowledges them in one ACK.
+// NAKs are sent for missing datagrams, and datagrams missing for longer than the skip delay are skipped.
 func (window *ReceiveWindow) Tick() {
+	var ack []uint32
 	for len(window.pendingDatagrams) > 0 {
 		datagram := <-window.pendingDatagrams
-		window.datagrams[datagram.SequenceNumber] = datagram
-	}
-	for i := window.expectedSequenceNumber;; i++ {
-		if datagram, ok := window.datagrams[i]; ok {
-			go window.DatagramHandleFunction(datagram)
-			window.expectedSequenceNumber++
-			delete(window.datagrams, i)
+		sequenceNumber := datagram.SequenceNumber & triadMask
+		if (sequenceNumber-window.expectedSequenceNumber)&triadMask >= ReceiveWindowSize && !isOlderIndex(sequenceNumber, window.expectedSequenceNumber) {
+			continue
+		}
+		if isOlderIndex(sequenceNumber, window.expectedSequenceNumber) {
+			// Datagrams received before are acknowledged again, as our previous ACK may have been lost.
+			ack = append(ack, sequenceNumber)
+			continue
+		}
+		if window.AcceptFunction(datagram.Datagram) {
+			ack = append(ack, sequenceNumber)
 		} else {
+			// Refused datagrams are held without their datagram, so that they are skipped once released.
+			datagram.Datagram = nil
+		}
+		if isOlderIndex(window.highestSequenceNumber, sequenceNumber) {
+			window.highestSequenceNumber = sequenceNumber
+		}
+		window.datagrams[sequenceNumber] = datagram
+		delete(window.missingDatagrams, sequenceNumber)
+	}
+	if len(ack) > 0 {
+		window.ACKFunction(ack)
+	}
+
+	now := window.Clock.Now()
+	for {
+		window.release()
+		missing, ok := window.missingDatagrams[window.expectedSequenceNumber]
+		if !ok || now.Sub(missing.detected) < window.SkipDelay {
+			break
+		}
+		delete(window.missingDatagrams, window.expectedSequenceNumber)
+		window.expectedSequenceNumber = (window.expectedSequenceNumber + 1) & triadMask
+	}
+
+	var nak []uint32
+	for i := window.expectedSequenceNumber; isOlderIndex(i, window.highestSequenceNumber); i = (i + 1) & triadMask {
+		if _, ok := window.datagrams[i]; ok {
+			continue
+		}
+		missing, ok := window.missingDatagrams[i]
+		if !ok {
+			missing = &missingDatagram{detected: now}
+			window.missingDatagrams[i] = missing
+		}
+		if now.Sub(missing.nakSent) >= window.NAKInterval {
+			missing.nakSent = now
+			nak = append(nak, i)
+		}
+	}
+	if len(nak) > 0 {
+		window.NAKFunction(nak)
+	}
+}
+
+// release releases all datagrams from the expected sequence number onwards,
+// until a datagram is missing.
+func (window *ReceiveWindow) release() {
+	for {
+		datagram, ok := window.datagrams[window.expectedSequenceNumber]
+		if !ok {
 			return
 		}
+		delete(window.datagrams, window.expectedSequenceNumber)
+		if datagram.Datagram == nil {
+			window.expectedSequenceNumber = (window.expectedSequenceNumber + 1) & triadMask
+			continue
+		}
+		if window.Synchronous {
+			window.DatagramHandleFunction(datagram)
+			window.expectedSequenceNumber = (window.expectedSequenceNumber + 1) & triadMask
+			continue
+		}
+		atomic.AddInt32(&window.handling, 1)
+		go func(datagram TimestampedDatagram) {
+			defer atomic.AddInt32(&window.handling, -1)
+			window.DatagramHandleFunction(datagram)
+		}(datagram)
+		window.expectedSequenceNumber = (window.expectedSequenceNumber + 1) & triadMask
 	}
-}
\ No newline at end of file
+}
+
+// Handling returns the amount of released datagrams that are still being handled,
+// which is the amount of calls to the DatagramHandleFunction that have not yet returned.
+func (window *ReceiveWindow) Handling() int {
+	return int(atomic.LoadInt32(&window.handling))
+}
@@ -1,37 +1,102 @@
 package server
 
 import (
+	"sort"
 	"sync"
+	"time"
 	"github.com/irmine/goraklib/protocol"
 )
 
+const (
+	// DefaultRetransmissionLimit is the default amount of times a datagram may be retransmitted.
+	// The session is considered failed once a datagram needs to be retransmitted more often.
+	DefaultRetransmissionLimit = 10
+	// InitialRetransmissionTimeout is the retransmission timeout used until the first RTT has been measured.
+	InitialRetransmissionTimeout = time.Second
+	// MinimumRetransmissionTimeout is the lowest retransmission timeout possible.
+	MinimumRetransmissionTimeout = time.Millisecond * 100
+	// MaximumRetransmissionTimeout is the highest retransmission timeout possible, including backoff.
+	MaximumRetransmissionTimeout = time.Second * 5
+)
+
 // A RecoveryQueue manages the recovery of lost datagrams over the connection.
-// Datagrams get restored by the client sending a NAK.
+// Datagrams get restored by the client sending a NAK, or once their retransmission timeout runs out.
 // The recovery queue holds every datagram sent and releases them,
 // once an ACK is received with the datagram's sequence number.
 type RecoveryQueue struct {
 	sync.Mutex
-	datagrams map[uint32]*protocol.Datagram
+	// NextSequenceNumber is a function that returns the next send sequence number of the session.
+	// Retransmitted datagrams are sent with a new sequence number.
+	// Sequence numbers are sent as triads, so only the lower 24 bits of the sequence number are used.
+	NextSequenceNumber func() uint32
+	// RetransmissionLimit is the maximum amount of times a single datagram may be retransmitted.
+	RetransmissionLimit int
+	// AcknowledgeFunction gets called with every datagram removed from the queue by an ACK.
+	AcknowledgeFunction func(datagram *protocol.Datagram)
+	// LossFunction gets called with every datagram dropped from the queue without being acknowledged.
+	LossFunction func(datagram *protocol.Datagram)
+	// RTT is the RTT estimator used to calculate the retransmission timeout.
+	// It is given an RTT sample for every datagram ACKed without being retransmitted.
+	RTT *RTTEstimator
+	// Clock is the clock send times and retransmission timeouts are measured with.
+	Clock Clock
+
+	datagrams     map[uint32]*recovery
+	bytesInFlight int
+}
+
+// recovery is a datagram held in the recovery queue,
+// along with the information needed to retransmit it.
+type recovery struct {
+	datagram        *protocol.Datagram
+	sendTime        time.Time
+	timeout         time.Duration
+	retransmissions int
+	reliable        bool
 }
 
 // NewRecoveryQueue returns a new recovery queue.
 func NewRecoveryQueue() *RecoveryQueue {
-	return &RecoveryQueue{sync.Mutex{}, make(map[uint32]*protocol.Datagram)}
+	queue := &RecoveryQueue{datagrams: make(map[uint32]*recovery), RetransmissionLimit: DefaultRetransmissionLimit, RTT: NewRTTEstimator(), Clock: SystemClock{},
+		AcknowledgeFunction: func(datagram *protocol.Datagram) {}, LossFunction: func(datagram *protocol.Datagram) {}}
+	var sequenceNumber uint32
+	queue.NextSequenceNumber = func() uint32 {
+		sequenceNumber = (sequenceNumber + 1) & triadMask
+		return sequenceNumber
+	}
+	return queue
 }
 
 // AddRecovery adds recovery for the given datagram.
 // The recovery will consist until an ACK gets sent by the client,
 // and the datagram is safe to be removed.
+// The send time of the datagram is recorded for retransmission and RTT measurement.
+// Datagrams are held by their sequence number as a triad, as it is sent on the wire.
 func (queue *RecoveryQueue) AddRecovery(datagram *protocol.Datagram) {
+	reliable := false
+	for _, packet := range *datagram.GetPackets() {
+		if packet.IsReliable() {
+			reliable = true
+			break
+		}
+	}
 	queue.Lock()
-	queue.datagrams[datagram.SequenceNumber] = datagram
+	queue.datagrams[datagram.SequenceNumber&triadMask] = &recovery{datagram, queue.Clock.Now(), queue.RTT.RetransmissionTimeout(), 0, reliable}
+	queue.bytesInFlight += len(datagram.Buffer)
 	queue.Unlock()
 }
 
+// BytesInFlight returns the amount of bytes of all datagrams sent that have not yet been acknowledged.
+func (queue *RecoveryQueue) BytesInFlight() int {
+	queue.Lock()
+	defer queue.Unlock()
+	return queue.bytesInFlight
+}
+
 // IsRecoverable checks if the datagram with the given sequence number is recoverable.
 func (queue *RecoveryQueue) IsRecoverable(sequenceNumber uint32) bool {
 	queue.Lock()
-	_, ok := queue.datagrams[sequenceNumber]
+	_, ok := queue.datagrams[sequenceNumber&triadMask]
 	queue.Unlock()
 	return ok
 }
@@ -39,27 +104,109 @@ func (queue *RecoveryQueue) IsRecoverable(sequenceNumber uint32) bool {
 // RemoveRecovery removes recovery for all sequence numbers given.
 // Removed datagrams can not be retrieved in any way,
 // therefore this function should only be used once the client sends an ACK to ensure arrival.
-func (queue *RecoveryQueue) RemoveRecovery(sequenceNumbers []uint32) {
+// Datagrams that were never retransmitted are used to measure the RTT.
+// RemoveRecovery returns the amount of bytes of the datagrams removed.
+func (queue *RecoveryQueue) RemoveRecovery(sequenceNumbers []uint32) int {
+	now := queue.Clock.Now()
+	bytes := 0
+	var acknowledged []*protocol.Datagram
 	queue.Lock()
 	for _, sequenceNumber := range sequenceNumbers {
-		delete(queue.datagrams, sequenceNumber)
+		sequenceNumber &= triadMask
+		if recovery, ok := queue.datagrams[sequenceNumber]; ok {
+			if recovery.retransmissions == 0 {
+				queue.RTT.AddSample(now.Sub(recovery.sendTime))
+			}
+			bytes += len(recovery.datagram.Buffer)
+			acknowledged = append(acknowledged, recovery.datagram)
+			delete(queue.datagrams, sequenceNumber)
+		}
 	}
+	queue.bytesInFlight -= bytes
 	queue.Unlock()
+
+	for _, datagram := range acknowledged {
+		queue.AcknowledgeFunction(datagram)
+	}
+	return bytes
 }
 
 // Recover recovers all datagrams associated with the sequence numbers in the array given.
 // Every recoverable datagram with sequence number in the array will be returned,
 // along with an array containing all recovered sequence numbers.
+// The datagrams returned have been given a new sequence number, and should be resent immediately.
 func (queue *RecoveryQueue) Recover(sequenceNumbers []uint32) ([]*protocol.Datagram, []uint32) {
 	var datagrams []*protocol.Datagram
 	var recoveredSequenceNumbers []uint32
+	now := queue.Clock.Now()
 	queue.Lock()
 	for _, sequenceNumber := range sequenceNumbers {
-		if datagram, ok := queue.datagrams[sequenceNumber]; ok {
-			datagrams = append(datagrams, datagram)
+		if recovery, ok := queue.datagrams[sequenceNumber&triadMask]; ok {
+			queue.retransmit(recovery, now)
+			datagrams = append(datagrams, recovery.datagram)
 			recoveredSequenceNumbers = append(recoveredSequenceNumbers, sequenceNumber)
 		}
 	}
 	queue.Unlock()
-	return datagrams, sequenceNumbers
-}
\ No newline at end of file
+	return datagrams, recoveredSequenceNumbers
+}
+
+// Tick checks all datagrams in the recovery queue for an expired retransmission timeout.
+// Reliable datagrams that expired are returned with a new sequence number, and should be resent immediately.
+// Datagrams without reliable packets are not retransmitted, but simply dropped once expired.
+// Tick returns false if a datagram has exceeded the retransmission limit,
+// in which case the session should be closed.
+func (queue *RecoveryQueue) Tick() ([]*protocol.Datagram, bool) {
+	var datagrams, lost []*protocol.Datagram
+	now := queue.Clock.Now()
+	ok := true
+	queue.Lock()
+	// Datagrams are checked in order of their sequence numbers, so that they are retransmitted in the order they were sent.
+	// Sequence numbers wrap around once they exceed a triad, so they are ordered like the receive window orders them.
+	sequenceNumbers := make([]uint32, 0, len(queue.datagrams))
+	for sequenceNumber := range queue.datagrams {
+		sequenceNumbers = append(sequenceNumbers, sequenceNumber)
+	}
+	sort.Slice(sequenceNumbers, func(i, j int) bool {
+		return isOlderIndex(sequenceNumbers[i], sequenceNumbers[j])
+	})
+	for _, sequenceNumber := range sequenceNumbers {
+		recovery := queue.datagrams[sequenceNumber]
+		if now.Sub(recovery.sendTime) < recovery.timeout {
+			continue
+		}
+		if !recovery.reliable {
+			queue.bytesInFlight -= len(recovery.datagram.Buffer)
+			delete(queue.datagrams, sequenceNumber)
+			lost = append(lost, recovery.datagram)
+			continue
+		}
+		if recovery.retransmissions >= queue.RetransmissionLimit {
+			ok = false
+			datagrams = nil
+			break
+		}
+		queue.retransmit(recovery, now)
+		datagrams = append(datagrams, recovery.datagram)
+	}
+	queue.Unlock()
+
+	for _, datagram := range lost {
+		queue.LossFunction(datagram)
+	}
+	return datagrams, ok
+}
+
+// retransmit moves a recovery to a new sequence number, and doubles its retransmission timeout.
+// The queue must be locked while calling retransmit.
+func (queue *RecoveryQueue) retransmit(recovery *recovery, now time.Time) {
+	delete(queue.datagrams, recovery.datagram.SequenceNumber&triadMask)
+	recovery.datagram.SetSequenceNumber(queue.NextSequenceNumber() & triadMask)
+	recovery.retransmissions++
+	recovery.sendTime = now
+	recovery.timeout *= 2
+	if recovery.timeout > MaximumRetransmissionTimeout {
+		recovery.timeout = MaximumRetransmissionTimeout
+	}
+	queue.datagrams[recovery.datagram.SequenceNumber] = recovery
+}
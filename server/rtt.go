@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// RTTStats holds the round trip time statistics of a session.
+// All durations are zero until the first RTT sample has been taken.
+type RTTStats struct {
+	// Smoothed is the smoothed RTT, which is the moving average of all RTT samples.
+	Smoothed time.Duration
+	// Variance is the mean deviation of RTT samples from the smoothed RTT.
+	Variance time.Duration
+	// Minimum is the lowest RTT sample taken.
+	Minimum time.Duration
+	// Latest is the most recent RTT sample taken.
+	Latest time.Duration
+	// Samples is the amount of RTT samples taken.
+	Samples int64
+}
+
+// An RTTEstimator estimates the round trip time of a session.
+// RTT samples are taken from ACKs of datagrams sent once, matched against their send time,
+// and from connected pongs replying to connected pings.
+// The smoothed RTT and RTT variance are calculated as described in RFC 6298.
+type RTTEstimator struct {
+	sync.Mutex
+	stats RTTStats
+}
+
+// NewRTTEstimator returns a new RTT estimator without any samples.
+func NewRTTEstimator() *RTTEstimator {
+	return &RTTEstimator{}
+}
+
+// AddSample adds a new RTT sample to the estimator.
+// Negative samples are ignored.
+func (estimator *RTTEstimator) AddSample(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+	estimator.Lock()
+	defer estimator.Unlock()
+	stats := &estimator.stats
+	stats.Latest = rtt
+	stats.Samples++
+	if stats.Samples == 1 {
+		stats.Smoothed = rtt
+		stats.Variance = rtt / 2
+		stats.Minimum = rtt
+		return
+	}
+	if rtt < stats.Minimum {
+		stats.Minimum = rtt
+	}
+	difference := stats.Smoothed - rtt
+	if difference < 0 {
+		difference = -difference
+	}
+	stats.Variance = (stats.Variance*3 + difference) / 4
+	stats.Smoothed = (stats.Smoothed*7 + rtt) / 8
+}
+
+// Stats returns a snapshot of the current RTT statistics.
+func (estimator *RTTEstimator) Stats() RTTStats {
+	estimator.Lock()
+	defer estimator.Unlock()
+	return estimator.stats
+}
+
+// RetransmissionTimeout returns the current retransmission timeout,
+// based on the smoothed RTT and RTT variance.
+// The InitialRetransmissionTimeout is returned if no samples have been taken yet.
+func (estimator *RTTEstimator) RetransmissionTimeout() time.Duration {
+	stats := estimator.Stats()
+	if stats.Samples == 0 {
+		return InitialRetransmissionTimeout
+	}
+	timeout := stats.Smoothed + stats.Variance*4
+	if timeout < MinimumRetransmissionTimeout {
+		return MinimumRetransmissionTimeout
+	}
+	if timeout > MaximumRetransmissionTimeout {
+		return MaximumRetransmissionTimeout
+	}
+	return timeout
+}
+
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"log/slog"
 	"net"
-	"fmt"
+	"sort"
 	"github.com/irmine/goraklib/protocol"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,6 +17,10 @@ type Session struct {
 	Manager       *Manager
 	ReceiveWindow *ReceiveWindow
 	RecoveryQueue *RecoveryQueue
+	// CongestionController limits the amount of unacknowledged bytes in flight.
+	CongestionController CongestionController
+	OrderQueue    *OrderQueue
+	MessageWindow *MessageWindow
 
 	// MTUSize is the maximum size of packets sent and received to and from this sessoin.
 	MTUSize 	int16
@@ -22,15 +28,51 @@ type Session struct {
 	Indexes 	Indexes
 	// Queues holds all send queues of the session.
 	Queues 		Queues
+	// Mutex protects the client ID, the last update time and the close flag of the session,
+	// which are written while the session handles packets.
+	sync.Mutex
 	// ClientId is the unique client ID of the session.
+	// It is set once the connection request of the session is handled. Use GetClientId to read it safely.
 	ClientId 	uint64
-	// CurrentPing is the current latency of the session.
-	CurrentPing int64
+	// RTTEstimator estimates the round trip time of the session.
+	RTTEstimator *RTTEstimator
+	// Receipts tracks the delivery of packets sent with one of the WithAck reliabilities.
+	Receipts *ReceiptTracker
 	// LastUpdate is the last update time of the session.
+	// Use GetLastUpdate to read it safely.
 	LastUpdate	time.Time
 	// FlaggedForClose indicates if this session has been flagged to close.
-	// Sessions flagged for close will be closed next tick safely.
+	// Sessions flagged for close will be closed next tick safely. Use IsFlaggedForClose to read it safely.
 	FlaggedForClose bool
+
+	// disconnectReason is the reason the session was flagged for close with.
+	disconnectReason DisconnectReason
+	// counters holds the traffic counters of the session.
+	counters counters
+	// clock is the clock of the manager of the session.
+	clock Clock
+	// disconnecting holds the disconnect notification sent by Disconnect, until the session is flagged for close.
+	disconnecting atomic.Pointer[pendingDisconnect]
+	// closed indicates if the session has been closed.
+	closed atomic.Bool
+	// done is closed once the session is closed, which unblocks packets waiting for room in a full queue.
+	done chan struct{}
+	// indexedClientId is the client ID the session is indexed by in the session manager, if clientIdIndexed is true.
+	// Both are protected by the lock of the session.
+	indexedClientId uint64
+	clientIdIndexed bool
+	// dialed indicates if the session was dialed by a client, in which case the other end is the server.
+	dialed bool
+	// connected indicates if the connection request of the session has been accepted.
+	connected atomic.Bool
+}
+
+// pendingDisconnect is a disconnect notification sent, of which the session waits for the acknowledgement.
+type pendingDisconnect struct {
+	reason   DisconnectReason
+	deadline time.Time
+	// receipt is the delivery receipt of the notification, which is set once the notification has been sent.
+	receipt atomic.Pointer[Receipt]
 }
 
 // Queues is a container of four priority queues.
@@ -51,8 +93,18 @@ type Indexes struct {
 	splitId       int16
 	sendSequence  uint32
 	messageIndex  uint32
-	orderIndex    uint32 // TODO: Implement proper order channels and indexes.
-	sequenceIndex uint32
+	orderIndex    [OrderChannelCount]uint32
+	sequenceIndex [OrderChannelCount]uint32
+}
+
+// NextSendSequence returns the sequence number for the next datagram sent,
+// and increments the send sequence. The send sequence wraps around once it exceeds a triad.
+func (indexes *Indexes) NextSendSequence() uint32 {
+	indexes.Lock()
+	sequenceNumber := indexes.sendSequence
+	indexes.sendSequence = (indexes.sendSequence + 1) & triadMask
+	indexes.Unlock()
+	return sequenceNumber
 }
 
 // NewSession returns a new session with UDP address.
@@ -62,83 +114,333 @@ func NewSession(addr *net.UDPAddr, mtuSize int16, manager *Manager) *Session {
 		manager,
 		NewReceiveWindow(),
 		NewRecoveryQueue(),
+		manager.NewCongestionController(mtuSize),
+		NewOrderQueue(),
+		NewMessageWindow(),
 		mtuSize,
-		Indexes{sync.Mutex{}, make(map[int16][]*protocol.EncapsulatedPacket), make(map[int16]uint), 0, 0, 0, 0, 0},
+		Indexes{sync.Mutex{}, make(map[int16][]*protocol.EncapsulatedPacket), make(map[int16]uint), 0, 0, 0, [OrderChannelCount]uint32{}, [OrderChannelCount]uint32{}},
 		Queues{NewPriorityQueue(1), NewPriorityQueue(256), NewPriorityQueue(256), NewPriorityQueue(256)},
+		sync.Mutex{},
 		0,
+		NewRTTEstimator(),
+		NewReceiptTracker(),
+		manager.Clock.Now(),
+		false,
+		DisconnectReasonKicked,
+		counters{},
+		manager.Clock,
+		atomic.Pointer[pendingDisconnect]{},
+		atomic.Bool{},
+		make(chan struct{}),
 		0,
-		time.Now(),
 		false,
+		false,
+		atomic.Bool{},
 	}
 	session.ReceiveWindow.DatagramHandleFunction = func(datagram TimestampedDatagram) {
-		session.LastUpdate = time.Now()
-		session.SendACK(datagram.SequenceNumber)
+		// Datagrams released before the session closed may still be handled after it.
+		if session.IsClosed() {
+			return
+		}
+		session.refresh()
 		session.HandleDatagram(datagram)
 	}
+	session.ReceiveWindow.Clock = manager.Clock
+	session.ReceiveWindow.Synchronous = manager.HandleSynchronously
+	session.RecoveryQueue.Clock = manager.Clock
+	if window, ok := session.CongestionController.(*SlidingWindow); ok {
+		window.Clock = manager.Clock
+	}
+	session.ReceiveWindow.ACKFunction = session.SendACK
+	session.ReceiveWindow.NAKFunction = session.SendNAK
+	session.ReceiveWindow.AcceptFunction = session.fitsMessageWindow
+	session.RecoveryQueue.NextSequenceNumber = session.Indexes.NextSendSequence
+	session.RecoveryQueue.RTT = session.RTTEstimator
+	session.RecoveryQueue.AcknowledgeFunction = session.Receipts.Acknowledge
+	session.RecoveryQueue.LossFunction = session.Receipts.Lose
 	return session
 }
 
-// Close removes all references of the session,
-// and removes the capability to send and handle packets.
-// Sessions can not be opened once closed.
+// NewDialedSession returns a new session with UDP address of a server dialed by a client.
+// Dialed sessions send the connection request themselves, and are connected once the server accepted it.
+func NewDialedSession(addr *net.UDPAddr, mtuSize int16, manager *Manager) *Session {
+	session := NewSession(addr, mtuSize, manager)
+	session.dialed = true
+	return session
+}
+
+// Close closes the session, which removes the capability to send and handle packets.
+// Sessions can not be opened once closed, and closing a session more than once has no effect.
 // It is strongly unrecommended to use this function directly.
 // Use FlagForClose instead.
+// Delivery receipts of packets not yet acknowledged are considered lost.
+// The DisconnectFunction of the manager is called with the reason the session was flagged for close with.
+// The fields of the session are kept once closed, so that the session may still be used concurrently.
 func (session *Session) Close() {
-	session.Manager.DisconnectFunction(session)
-	session.UDPAddr = nil
-	session.Manager = nil
-	session.ReceiveWindow = nil
-	session.RecoveryQueue = nil
-	session.Indexes = Indexes{}
-	session.Queues = Queues{}
+	if !session.closed.CompareAndSwap(false, true) {
+		return
+	}
+	close(session.done)
+	session.Lock()
+	reason := session.disconnectReason
+	session.Unlock()
+	session.logger().Info("session disconnected", "reason", reason.String())
+	session.Receipts.LoseAll()
+	session.Manager.DisconnectFunction(session, reason)
+}
+
+// Disconnect disconnects the session for the given reason.
+// A disconnect notification is sent to the session reliably, and the session is flagged for close
+// once the notification has been acknowledged, or once the DisconnectTimeout passes.
+// Disconnect does not block, and the DisconnectFunction of the manager is called once the session closes.
+// Only the first call to Disconnect sends a notification; Any further calls have no effect.
+func (session *Session) Disconnect(reason DisconnectReason) {
+	if session.IsClosed() {
+		return
+	}
+	disconnect := &pendingDisconnect{reason: reason, deadline: session.clock.Now().Add(DisconnectTimeout)}
+	if !session.disconnecting.CompareAndSwap(nil, disconnect) {
+		return
+	}
+	disconnect.receipt.Store(session.SendPacket(protocol.NewDisconnectNotification(), protocol.ReliabilityReliableOrderedWithAck, PriorityImmediate, 0))
+}
+
+// checkDisconnect flags the session for close if the disconnect notification sent by Disconnect
+// has been acknowledged or lost, or if the DisconnectTimeout passed.
+func (session *Session) checkDisconnect() {
+	disconnect := session.disconnecting.Load()
+	if disconnect == nil {
+		return
+	}
+	acknowledged := false
+	if receipt := disconnect.receipt.Load(); receipt != nil {
+		select {
+		case <-receipt.Done():
+			acknowledged = true
+		default:
+		}
+	}
+	if !acknowledged && session.clock.Now().Before(disconnect.deadline) {
+		return
+	}
+	session.flagForClose(disconnect.reason)
+}
+
+// notifyDisconnect flushes all queues of the session without limit,
+// and sends the session a disconnect notification.
+func (session *Session) notifyDisconnect() {
+	if session.IsClosed() {
+		return
+	}
+	session.Queues.High.flush(session, false)
+	session.Queues.Medium.flush(session, false)
+	session.Queues.Low.flush(session, false)
+	session.SendPacket(protocol.NewDisconnectNotification(), protocol.ReliabilityReliableOrdered, PriorityImmediate, 0)
+}
+
+// isFlushed checks if all queues of the session are empty,
+// and all datagrams sent to the session have been acknowledged.
+func (session *Session) isFlushed() bool {
+	return len(*session.Queues.High) == 0 && len(*session.Queues.Medium) == 0 && len(*session.Queues.Low) == 0 &&
+		session.RecoveryQueue.BytesInFlight() == 0
 }
 
 // FlagForClose flags the session for close.
 // It is always recommended to use this function over direct Close.
-// Sessions flagged for close will be closed the next tick.
+// Sessions flagged for close will be closed the next tick, without notifying the other end.
+// The session is closed with DisconnectReasonKicked. Use Disconnect to notify the other end.
 func (session *Session) FlagForClose() {
+	session.flagForClose(DisconnectReasonKicked)
+}
+
+// Abort flags the session for close with the given reason, without notifying the other end.
+// The session will be closed the next tick, like a session flagged using FlagForClose.
+func (session *Session) Abort(reason DisconnectReason) {
+	session.flagForClose(reason)
+}
+
+// flagForClose flags the session for close with the given reason.
+// The reason is not changed if the session was already flagged for close.
+func (session *Session) flagForClose(reason DisconnectReason) {
+	session.Lock()
+	defer session.Unlock()
+	if session.FlaggedForClose {
+		return
+	}
+	session.disconnectReason = reason
 	session.FlaggedForClose = true
 }
 
+// IsFlaggedForClose checks if the session has been flagged for close.
+func (session *Session) IsFlaggedForClose() bool {
+	session.Lock()
+	defer session.Unlock()
+	return session.FlaggedForClose
+}
+
+// GetClientId returns the client ID of the session, which is 0 until the connection request is handled.
+func (session *Session) GetClientId() uint64 {
+	session.Lock()
+	defer session.Unlock()
+	return session.ClientId
+}
+
+// GetLastUpdate returns the last time the session received a packet.
+func (session *Session) GetLastUpdate() time.Time {
+	session.Lock()
+	defer session.Unlock()
+	return session.LastUpdate
+}
+
+// refresh sets the last update time of the session to the current time.
+func (session *Session) refresh() {
+	now := session.clock.Now()
+	session.Lock()
+	session.LastUpdate = now
+	session.Unlock()
+}
+
+// RTT returns a snapshot of the round trip time statistics of the session.
+// The smoothed RTT, RTT variance and minimum RTT are measured from ACKs and connected pongs.
+func (session *Session) RTT() RTTStats {
+	return session.RTTEstimator.Stats()
+}
+
+// CurrentPing returns the current latency of the session in milliseconds.
+// It is the smoothed RTT of the session. Use RTT for more detailed statistics.
+func (session *Session) CurrentPing() int64 {
+	return int64(session.RTTEstimator.Stats().Smoothed / time.Millisecond)
+}
+
+// logger returns the logger of the manager, with the address and client ID of the session added.
+func (session *Session) logger() *slog.Logger {
+	return session.Manager.Logger.With("address", session.UDPAddr.String(), "clientId", session.GetClientId())
+}
+
 // IsClosed checks if the session is closed.
 // Sending and handling packets for a session is
 // impossible once the session is closed.
 func (session *Session) IsClosed() bool {
-	return session.UDPAddr == nil
+	return session.closed.Load()
 }
 
 // Send sends the given buffer to the session over UDP.
 // Returns an int describing the amount of bytes written,
-// and an error if unsuccessful.
+// and an error if unsuccessful. Nothing is sent once the session is closed.
 func (session *Session) Send(buffer []byte) (int, error) {
+	if session.IsClosed() {
+		return 0, net.ErrClosed
+	}
+	session.count(counterBytesSent, len(buffer))
 	return session.Manager.Server.Write(buffer, session.UDPAddr)
 }
 
-// SendACK sends an ACK packet to the session for the given sequence number.
+// SendACK sends ACK packets to the session for the given sequence numbers.
 // ACKs should only be sent once a datagram is received.
-func (session *Session) SendACK(sequenceNumber uint32) {
-	ack := protocol.NewACK()
-	ack.Packets = []uint32{sequenceNumber}
-	ack.Encode()
-	session.Send(ack.Buffer)
+// Consecutive sequence numbers are encoded as ranges, so that usually a single ACK is sent.
+func (session *Session) SendACK(sequenceNumbers []uint32) {
+	for _, chunk := range session.chunkAcknowledgements(sequenceNumbers) {
+		ack := protocol.NewACK()
+		ack.Packets = chunk
+		ack.Encode()
+		session.count(counterACKsSent, 1)
+		session.Send(ack.Buffer)
+	}
+}
+
+// SendNAK sends NAK packets to the session for the given sequence numbers.
+// NAKs are sent for datagrams found missing, in order for them to be resent.
+func (session *Session) SendNAK(sequenceNumbers []uint32) {
+	for _, chunk := range session.chunkAcknowledgements(sequenceNumbers) {
+		nak := protocol.NewNAK()
+		nak.Packets = chunk
+		nak.Encode()
+		session.count(counterNAKsSent, 1)
+		session.Send(nak.Buffer)
+	}
+}
+
+// chunkAcknowledgements splits sequence numbers into chunks that are guaranteed to fit
+// in a single ACK or NAK within the MTU size.
+// Chunks are limited by the amount of ranges of consecutive sequence numbers in them.
+// Ranges are counted as at most 512 sequence numbers long, so that a chunk never holds fewer ranges
+// than the ACK or NAK it is encoded in, which splits ranges longer than the longest range decoded.
+func (session *Session) chunkAcknowledgements(sequenceNumbers []uint32) [][]uint32 {
+	sort.Slice(sequenceNumbers, func(i, j int) bool {
+		return sequenceNumbers[i] < sequenceNumbers[j]
+	})
+	maximumRanges := int(session.MTUSize - 60) / 7
+	var chunks [][]uint32
+	start, ranges, rangeLength := 0, 1, 1
+	for i := 1; i < len(sequenceNumbers); i++ {
+		consecutive := sequenceNumbers[i] - sequenceNumbers[i - 1] <= 1
+		if consecutive && rangeLength < 512 {
+			rangeLength++
+			continue
+		}
+		ranges++
+		rangeLength = 1
+		if ranges > maximumRanges {
+			chunks = append(chunks, sequenceNumbers[start:i])
+			start, ranges = i, 1
+		}
+	}
+	return append(chunks, sequenceNumbers[start:])
+}
+
+// fitsMessageWindow checks if all reliable messages of the datagram fit in the message window.
+// Datagrams carrying messages beyond the window are not acknowledged, as their messages would be dropped.
+// The session is refreshed regardless, so that it does not time out while the window is full.
+func (session *Session) fitsMessageWindow(datagram *protocol.Datagram) bool {
+	session.refresh()
+	for _, packet := range *datagram.GetPackets() {
+		if packet.IsReliable() && !session.MessageWindow.Fits(packet.MessageIndex) {
+			return false
+		}
+	}
+	return true
 }
 
 // HandleDatagram handles an incoming datagram encapsulated by a timestamp.
 // The actual receive time of the datagram can be checked.
+// Reliable packets that have already been received before are dropped.
 func (session *Session) HandleDatagram(datagram TimestampedDatagram) {
 	for _, packet := range *datagram.GetPackets() {
+		if packet.IsReliable() && !session.MessageWindow.Receive(packet.MessageIndex) {
+			session.count(counterDropped, 1)
+			continue
+		}
 		if packet.HasSplit {
 			session.HandleSplitEncapsulated(packet, datagram.Timestamp)
 		} else {
-			session.HandleEncapsulated(packet, datagram.Timestamp)
+			session.HandleOrderedEncapsulated(packet, datagram.Timestamp)
 		}
 	}
 }
 
+// HandleOrderedEncapsulated handles an encapsulated packet that may be ordered or sequenced.
+// Ordered and sequenced packets are passed through the order queue first,
+// and get handled once all packets preceding them on their order channel have been handled.
+// Any other packets are handled immediately.
+func (session *Session) HandleOrderedEncapsulated(packet *protocol.EncapsulatedPacket, timestamp int64) {
+	if !packet.IsSequencedOrOrdered() {
+		session.HandleEncapsulated(packet, timestamp)
+		return
+	}
+	session.OrderQueue.Order(packet, func(packet *protocol.EncapsulatedPacket) {
+		session.HandleEncapsulated(packet, timestamp)
+	})
+}
+
 // HandleACK handles an incoming ACK packet.
-// Recovery gets removed for every datagram with a sequence number in the ACK.
+// Recovery gets removed for every datagram with a sequence number in the ACK,
+// which gives the RTT estimator new samples.
+// The congestion controller is informed of the bytes acknowledged.
 func (session *Session) HandleACK(ack *protocol.ACK) {
-	session.RecoveryQueue.RemoveRecovery(ack.Packets)
+	bytes := session.RecoveryQueue.RemoveRecovery(ack.Packets)
+	if bytes > 0 {
+		session.CongestionController.OnAcknowledged(bytes, session.RTTEstimator.Stats())
+	}
 }
 
 // HandleNACK handles an incoming NACK packet.
@@ -146,41 +448,58 @@ func (session *Session) HandleACK(ack *protocol.ACK) {
 func (session *Session) HandleNACK(nack *protocol.NAK) {
 	for _, seq := range nack.Packets {
 		if !session.RecoveryQueue.IsRecoverable(seq) {
-			fmt.Println("Unrecoverable datagram:", seq, "(", nack.Packets, ")")
+			session.logger().Debug("unrecoverable datagram NAKed", "sequenceNumber", seq)
 		}
 	}
 	datagrams, _ := session.RecoveryQueue.Recover(nack.Packets)
-	for _, datagram := range datagrams {
-		session.Manager.Server.Write(datagram.Buffer, session.UDPAddr)
+	if len(datagrams) > 0 {
+		session.logger().Debug("retransmitting NAKed datagrams", "datagrams", len(datagrams))
+		session.CongestionController.OnLoss(false, session.RTTEstimator.Stats())
 	}
+	session.retransmit(datagrams)
 }
 
 // HandleEncapsulated handles an encapsulated packet from a datagram.
 // A timestamp is passed, which is the timestamp of which the datagram received in the receive window.
+// Connection requests and new incoming connections are only handled by sessions of a server,
+// and connection accepts only by sessions dialed by a client.
 func (session *Session) HandleEncapsulated(packet *protocol.EncapsulatedPacket, timestamp int64) {
-	session.LastUpdate = time.Now()
+	session.refresh()
 	switch packet.Buffer[0] {
 	case protocol.IdConnectionRequest:
-		session.HandleConnectionRequest(packet)
+		if !session.dialed {
+			session.HandleConnectionRequest(packet)
+		}
+	case protocol.IdConnectionAccept:
+		if session.dialed {
+			session.HandleConnectionAccept(packet)
+		}
 	case protocol.IdNewIncomingConnection:
-		session.Manager.ConnectFunction(session)
+		if !session.dialed {
+			session.connect()
+		}
 	case protocol.IdConnectedPing:
 		session.HandleConnectedPing(packet, timestamp)
 	case protocol.IdConnectedPong:
 		session.HandleConnectedPong(packet, timestamp)
 	case protocol.IdDisconnectNotification:
-		session.FlagForClose()
+		session.logger().Debug("disconnect notification")
+		session.flagForClose(DisconnectReasonClientQuit)
 	default:
 		session.Manager.PacketFunction(packet.Buffer, session)
 	}
 }
 
 // HandleConnectedPong handles a pong reply of our own sent ping.
+// The RTT is measured from the millisecond timestamp of our ping, which is sent back in the pong.
 func (session *Session) HandleConnectedPong(packet *protocol.EncapsulatedPacket, timestamp int64) {
 	pong := protocol.NewConnectedPong()
 	pong.Buffer = packet.Buffer
-	pong.Decode()
-	session.CurrentPing = (timestamp - pong.PongSendTime) / int64(time.Millisecond)
+	if err := pong.Decode(); err != nil {
+		session.Manager.handleMalformedPacket(session.UDPAddr, err)
+		return
+	}
+	session.RTTEstimator.AddSample(time.Duration(timestamp - pong.PingSendTime) * time.Millisecond)
 }
 
 // HandleConnectedPing handles a connected ping from the client.
@@ -188,67 +507,157 @@ func (session *Session) HandleConnectedPong(packet *protocol.EncapsulatedPacket,
 func (session *Session) HandleConnectedPing(packet *protocol.EncapsulatedPacket, timestamp int64) {
 	ping := protocol.NewConnectedPing()
 	ping.Buffer = packet.Buffer
-	ping.Decode()
+	if err := ping.Decode(); err != nil {
+		session.Manager.handleMalformedPacket(session.UDPAddr, err)
+		return
+	}
 
 	pong := protocol.NewConnectedPong()
 	pong.PingSendTime = ping.PingSendTime
 	pong.PongSendTime = timestamp
 
-	session.SendPacket(pong, protocol.ReliabilityUnreliable, PriorityLow)
+	session.SendPacket(pong, protocol.ReliabilityUnreliable, PriorityLow, 0)
 }
 
 // HandleConnectionRequest handles a connection request from the session.
+// The session is indexed by the client ID in the request, unless another open session already uses it.
 // A connection accept gets sent back to the client.
 func (session *Session) HandleConnectionRequest(packet *protocol.EncapsulatedPacket) {
 	request := protocol.NewConnectionRequest()
 	request.Buffer = packet.GetBuffer()
-	request.Decode()
+	if err := request.Decode(); err != nil {
+		session.Manager.handleMalformedPacket(session.UDPAddr, err)
+		return
+	}
 
+	session.Lock()
 	session.ClientId = request.ClientId
+	session.Unlock()
+	session.logger().Debug("connection request")
+	if !session.Manager.Sessions.IndexClientId(session) {
+		session.logger().Debug("client ID already in use by another session")
+	}
 
 	accept := protocol.NewConnectionAccept()
 	accept.ClientAddress = session.UDPAddr.IP.String()
 	accept.ClientPort = uint16(session.UDPAddr.Port)
 
-	accept.PingSendTime = uint64(time.Now().Unix())
-	accept.PongSendTime = uint64(time.Now().Unix())
+	accept.PingSendTime = request.PingSendTime
+	accept.PongSendTime = uint64(millis(session.clock.Now()))
+
+	session.SendPacket(accept, protocol.ReliabilityReliableOrdered, PriorityImmediate, 0)
+}
+
+// HandleConnectionAccept handles a connection accept from the server.
+// Connection accepts are only received by sessions that were dialed by a client.
+// A new incoming connection gets sent back, after which the session is fully connected.
+// Connection accepts received once the session is connected are ignored.
+func (session *Session) HandleConnectionAccept(packet *protocol.EncapsulatedPacket) {
+	if session.connected.Load() {
+		return
+	}
+	accept := protocol.NewConnectionAccept()
+	accept.Buffer = packet.GetBuffer()
+	if err := accept.Decode(); err != nil {
+		session.Manager.handleMalformedPacket(session.UDPAddr, err)
+		return
+	}
+
+	connection := protocol.NewNewIncomingConnection()
+	connection.ServerAddress = session.UDPAddr.IP.String()
+	connection.ServerPort = uint16(session.UDPAddr.Port)
+
+	connection.PingSendTime = accept.PongSendTime
+	connection.PongSendTime = uint64(millis(session.clock.Now()))
 
-	session.SendPacket(accept, protocol.ReliabilityReliableOrdered, PriorityImmediate)
+	session.SendPacket(connection, protocol.ReliabilityReliableOrdered, PriorityImmediate, 0)
+	session.connect()
+}
+
+// connect marks the session as connected, and calls the ConnectFunction of the manager.
+// Sessions are only connected once; Any further calls have no effect.
+func (session *Session) connect() {
+	if !session.connected.CompareAndSwap(false, true) {
+		return
+	}
+	session.logger().Info("session connected")
+	session.Manager.ConnectFunction(session)
 }
 
 // HandleSplitEncapsulated handles a split encapsulated packet.
 // Split encapsulated packets are first collected into an array,
 // and are merged once all fragments of the encapsulated packets have arrived.
+// Packets split into more than MaximumSplitCount fragments are treated as malformed.
+// The merged packet carries the reliability and ordering of its fragments,
+// and is ordered like any other encapsulated packet.
 func (session *Session) HandleSplitEncapsulated(packet *protocol.EncapsulatedPacket, timestamp int64) {
+	if packet.SplitCount > MaximumSplitCount {
+		session.Manager.handleMalformedPacket(session.UDPAddr, errTooManySplits)
+		return
+	}
 	id := packet.SplitId
 	session.Indexes.Lock()
 	if session.Indexes.splits[id] == nil {
 		session.Indexes.splits[id] = make([]*protocol.EncapsulatedPacket, packet.SplitCount)
 		session.Indexes.splitCounts[id] = 0
 	}
+	if packet.SplitIndex >= uint(len(session.Indexes.splits[id])) {
+		session.Indexes.Unlock()
+		return
+	}
 	if pk := session.Indexes.splits[id][packet.SplitIndex]; pk == nil {
 		session.Indexes.splitCounts[id]++
 	}
 	session.Indexes.splits[id][packet.SplitIndex] = packet
-	if session.Indexes.splitCounts[id] == packet.SplitCount {
-		newPacket := protocol.NewEncapsulatedPacket()
-		for _, pk := range session.Indexes.splits[id] {
-			newPacket.PutBytes(pk.Buffer)
-		}
-		session.HandleEncapsulated(newPacket, timestamp)
-		delete(session.Indexes.splits, id)
+	if session.Indexes.splitCounts[id] != uint(len(session.Indexes.splits[id])) {
+		session.Indexes.Unlock()
+		return
 	}
+	newPacket := protocol.NewEncapsulatedPacket()
+	newPacket.Reliability = packet.Reliability
+	newPacket.MessageIndex = packet.MessageIndex
+	newPacket.OrderIndex = packet.OrderIndex
+	newPacket.OrderChannel = packet.OrderChannel
+	newPacket.SequenceIndex = packet.SequenceIndex
+	for _, pk := range session.Indexes.splits[id] {
+		newPacket.PutBytes(pk.Buffer)
+	}
+	delete(session.Indexes.splits, id)
+	delete(session.Indexes.splitCounts, id)
 	session.Indexes.Unlock()
+	session.count(counterSplitReassemblies, 1)
+
+	session.HandleOrderedEncapsulated(newPacket, timestamp)
 }
 
 // Tick ticks the session and processes the receive window and priority queues.
 // currentTick is the current tick of the server, which increments every time this function is ran.
+// Datagrams of which the retransmission timeout expired are retransmitted,
+// and the session gets flagged for close if a datagram exceeded the retransmission limit.
+// Closed sessions are not ticked.
 func (session *Session) Tick(currentTick int64) {
+	if session.IsClosed() {
+		return
+	}
+	session.checkDisconnect()
+	datagrams, ok := session.RecoveryQueue.Tick()
+	if !ok {
+		if !session.IsFlaggedForClose() {
+			session.logger().Warn("session timed out", "retransmissionLimit", session.RecoveryQueue.RetransmissionLimit)
+		}
+		session.flagForClose(DisconnectReasonTimeout)
+		return
+	}
+	if len(datagrams) > 0 {
+		session.logger().Debug("retransmitting timed out datagrams", "datagrams", len(datagrams))
+		session.CongestionController.OnLoss(true, session.RTTEstimator.Stats())
+	}
+	session.retransmit(datagrams)
 	session.Queues.High.Flush(session)
 	if currentTick % 400 == 0 {
 		ping := protocol.NewConnectedPing()
-		ping.PingSendTime = time.Now().Unix()
-		session.SendPacket(ping, protocol.ReliabilityUnreliable, PriorityImmediate)
+		ping.PingSendTime = millis(session.clock.Now())
+		session.SendPacket(ping, protocol.ReliabilityUnreliable, PriorityImmediate, 0)
 	}
 	if currentTick % 2 == 0 {
 		session.ReceiveWindow.Tick()
@@ -259,36 +668,74 @@ func (session *Session) Tick(currentTick int64) {
 	}
 }
 
+// retransmit resends the given datagrams to the session.
+func (session *Session) retransmit(datagrams []*protocol.Datagram) {
+	for _, datagram := range datagrams {
+		session.count(counterRetransmissions, 1)
+		session.count(counterDatagramsSent, 1)
+		session.Send(datagram.Buffer)
+	}
+}
+
 // SendPacket sends an external packet to a session.
 // The reliability given will be added to the encapsulated packet.
 // The packet will be added with the given priority. Immediate priority packets are sent out immediately.
-func (session *Session) SendPacket(packet protocol.IConnectedPacket, reliability byte, priority Priority) {
+// Ordered and sequenced packets are ordered on the given order channel, which ranges from 0 to 31.
+// Packets with an order channel out of that range are sent on order channel 0.
+// A delivery receipt is returned for packets sent with one of the WithAck reliabilities,
+// which is done once the packet has been acknowledged or is considered lost.
+// Nil is returned for packets sent with any other reliability.
+func (session *Session) SendPacket(packet protocol.IConnectedPacket, reliability byte, priority Priority, orderChannel byte) *Receipt {
+	if orderChannel >= OrderChannelCount {
+		orderChannel = 0
+	}
 	packet.Encode()
 	encapsulated := protocol.NewEncapsulatedPacket()
-	encapsulated.Reliability = reliability
+	encapsulated.OrderChannel = orderChannel
 	encapsulated.Buffer = packet.GetBuffer()
+
+	var receipt *Receipt
+	switch reliability {
+	case protocol.ReliabilityUnreliableWithAck:
+		receipt = session.Receipts.Track(encapsulated)
+		reliability = protocol.ReliabilityUnreliable
+	case protocol.ReliabilityReliableWithAck:
+		receipt = session.Receipts.Track(encapsulated)
+		reliability = protocol.ReliabilityReliable
+	case protocol.ReliabilityReliableOrderedWithAck:
+		receipt = session.Receipts.Track(encapsulated)
+		reliability = protocol.ReliabilityReliableOrdered
+	}
+	// The WithAck reliabilities are local only, and are sent as their plain counterparts.
+	encapsulated.Reliability = reliability
 	session.Queues.AddEncapsulated(encapsulated, priority, session)
+	return receipt
 }
 
-// AddEncapsulated adds an encapsulated packet at the given priority.
-// The queue gets flushed immediately if the priority is immediate priority.
-func (queues Queues) AddEncapsulated(packet *protocol.EncapsulatedPacket, priority Priority, session *Session) {
-	if session.IsClosed() {
-		return
-	}
-	var queue *PriorityQueue
+// get returns the queue of the given priority.
+func (queues Queues) get(priority Priority) *PriorityQueue {
 	switch priority {
 	case PriorityImmediate:
-		queue = queues.Immediate
+		return queues.Immediate
 	case PriorityHigh:
-		queue = queues.High
+		return queues.High
 	case PriorityMedium:
-		queue = queues.Medium
+		return queues.Medium
 	case PriorityLow:
-		queue = queues.Low
+		return queues.Low
+	}
+	return nil
+}
+
+// AddEncapsulated adds an encapsulated packet at the given priority.
+// The queue gets flushed immediately if the priority is immediate priority.
+func (queues Queues) AddEncapsulated(packet *protocol.EncapsulatedPacket, priority Priority, session *Session) {
+	if session.IsClosed() {
+		return
 	}
+	queue := queues.get(priority)
 	queue.AddEncapsulated(packet, session)
 	if priority == PriorityImmediate {
-		queue.Flush(session)
+		queue.flush(session, false)
 	}
 }
\ No newline at end of file
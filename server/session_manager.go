@@ -0,0 +1,232 @@
+package server
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/irmine/goraklib/protocol"
+)
+
+// sessionShardCount is the amount of shards sessions are spread over.
+// Every shard has its own lock, so that lookups of different sessions rarely contend.
+const sessionShardCount = 32
+
+// SessionManager is a manager of all sessions in the Manager.
+// Sessions are indexed by their UDP address and by their client ID.
+// The session manager is safe for concurrent use, and is sharded
+// so that lookups do not contend under thousands of sessions.
+type SessionManager struct {
+	shards         [sessionShardCount]*sessionShard
+	clientIdShards [sessionShardCount]*clientIdShard
+
+	// ipCounts holds the amount of sessions of every IP address.
+	ipCounts map[string]int
+	ipMutex  sync.Mutex
+}
+
+// sessionShard is a shard of sessions indexed by address key.
+type sessionShard struct {
+	sync.RWMutex
+	sessions map[string]*Session
+}
+
+// clientIdShard is a shard of sessions indexed by client ID.
+type clientIdShard struct {
+	sync.RWMutex
+	sessions map[uint64]*Session
+}
+
+// NewSessionManager returns a new session manager.
+func NewSessionManager() *SessionManager {
+	manager := &SessionManager{ipCounts: make(map[string]int)}
+	for i := 0; i < sessionShardCount; i++ {
+		manager.shards[i] = &sessionShard{sessions: make(map[string]*Session)}
+		manager.clientIdShards[i] = &clientIdShard{sessions: make(map[uint64]*Session)}
+	}
+	return manager
+}
+
+// SessionExists checks if the session manager has a session with a UDPAddr.
+func (manager *SessionManager) SessionExists(addr *net.UDPAddr) bool {
+	_, ok := manager.GetSession(addr)
+	return ok
+}
+
+// AddSession adds a session to the session manager.
+// The session is indexed by its UDP address, and by its client ID if it has one.
+// Any session previously added with the same UDP address is replaced.
+func (manager *SessionManager) AddSession(session *Session) {
+	key := addressKey(session.UDPAddr)
+	shard := manager.shard(key)
+	shard.Lock()
+	_, replaced := shard.sessions[key]
+	shard.sessions[key] = session
+	shard.Unlock()
+	if !replaced {
+		manager.ipMutex.Lock()
+		manager.ipCounts[ipKey(session.IP)]++
+		manager.ipMutex.Unlock()
+	}
+	if session.GetClientId() != 0 {
+		manager.IndexClientId(session)
+	}
+}
+
+// IndexClientId indexes a session by its client ID,
+// so that it can be found using GetSessionByClientId.
+// Sessions get indexed automatically once their connection request is handled.
+// The client ID the session was indexed by before is no longer indexed.
+// IndexClientId returns false if the session was not indexed, because it is not in the session manager,
+// or because its client ID is already indexed for another session that is not closed.
+func (manager *SessionManager) IndexClientId(session *Session) bool {
+	session.Lock()
+	defer session.Unlock()
+	manager.unindexClientId(session)
+	if current, ok := manager.GetSession(session.UDPAddr); !ok || current != session {
+		return false
+	}
+	shard := manager.clientIdShards[session.ClientId%sessionShardCount]
+	shard.Lock()
+	defer shard.Unlock()
+	if indexed, ok := shard.sessions[session.ClientId]; ok && indexed != session && !indexed.IsClosed() {
+		return false
+	}
+	shard.sessions[session.ClientId] = session
+	session.indexedClientId, session.clientIdIndexed = session.ClientId, true
+	return true
+}
+
+// unindexClientId removes the session from the client ID it is indexed by.
+// The session must be locked while calling unindexClientId.
+func (manager *SessionManager) unindexClientId(session *Session) {
+	if !session.clientIdIndexed {
+		return
+	}
+	clientId := session.indexedClientId
+	session.clientIdIndexed = false
+	shard := manager.clientIdShards[clientId%sessionShardCount]
+	shard.Lock()
+	if shard.sessions[clientId] == session {
+		delete(shard.sessions, clientId)
+	}
+	shard.Unlock()
+}
+
+// RemoveSession removes a session from the session manager.
+// The session can no longer be found by either its UDP address or client ID.
+func (manager *SessionManager) RemoveSession(session *Session) {
+	key := addressKey(session.UDPAddr)
+	shard := manager.shard(key)
+	shard.Lock()
+	removed := shard.sessions[key] == session
+	if removed {
+		delete(shard.sessions, key)
+	}
+	shard.Unlock()
+	if removed {
+		ip := ipKey(session.IP)
+		manager.ipMutex.Lock()
+		if manager.ipCounts[ip]--; manager.ipCounts[ip] <= 0 {
+			delete(manager.ipCounts, ip)
+		}
+		manager.ipMutex.Unlock()
+	}
+
+	session.Lock()
+	manager.unindexClientId(session)
+	session.Unlock()
+}
+
+// GetSession returns a session by a UDP address.
+// GetSession also returns a bool indicating success of the call.
+func (manager *SessionManager) GetSession(addr *net.UDPAddr) (*Session, bool) {
+	key := addressKey(addr)
+	shard := manager.shard(key)
+	shard.RLock()
+	session, ok := shard.sessions[key]
+	shard.RUnlock()
+	return session, ok
+}
+
+// GetSessionByClientId returns a session by its client ID.
+// GetSessionByClientId also returns a bool indicating success of the call.
+func (manager *SessionManager) GetSessionByClientId(clientId uint64) (*Session, bool) {
+	shard := manager.clientIdShards[clientId%sessionShardCount]
+	shard.RLock()
+	session, ok := shard.sessions[clientId]
+	shard.RUnlock()
+	return session, ok
+}
+
+// GetSessions returns a snapshot of all sessions in the session manager.
+// Sessions added or removed after the snapshot was taken are not reflected in it.
+func (manager *SessionManager) GetSessions() []*Session {
+	var sessions []*Session
+	for _, shard := range manager.shards {
+		shard.RLock()
+		for _, session := range shard.sessions {
+			sessions = append(sessions, session)
+		}
+		shard.RUnlock()
+	}
+	return sessions
+}
+
+// Count returns the amount of sessions in the session manager.
+func (manager *SessionManager) Count() int {
+	count := 0
+	for _, shard := range manager.shards {
+		shard.RLock()
+		count += len(shard.sessions)
+		shard.RUnlock()
+	}
+	return count
+}
+
+// CountIP returns the amount of sessions in the session manager with the given IP address.
+func (manager *SessionManager) CountIP(ip net.IP) int {
+	manager.ipMutex.Lock()
+	defer manager.ipMutex.Unlock()
+	return manager.ipCounts[ipKey(ip)]
+}
+
+// Broadcast sends a packet to every session in the session manager,
+// with the given reliability, priority and order channel.
+// Sessions flagged for close are skipped.
+func (manager *SessionManager) Broadcast(packet protocol.IConnectedPacket, reliability byte, priority Priority, orderChannel byte) {
+	for _, session := range manager.GetSessions() {
+		if session.IsFlaggedForClose() {
+			continue
+		}
+		session.SendPacket(packet, reliability, priority, orderChannel)
+	}
+}
+
+// shard returns the shard that holds the session with the given address key.
+func (manager *SessionManager) shard(key string) *sessionShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return manager.shards[hash.Sum32()%sessionShardCount]
+}
+
+// addressKey returns the key used to index a session by its UDP address.
+// IPv4-mapped IPv6 addresses, as read from dual stack sockets, get the same key as
+// their plain IPv4 address, and the zones of IPv6 addresses are kept.
+func addressKey(addr *net.UDPAddr) string {
+	host := ipKey(addr.IP)
+	if addr.Zone != "" && addr.IP.To4() == nil {
+		host += "%" + addr.Zone
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// ipKey returns the key used to count sessions by their IP address.
+// IPv4-mapped IPv6 addresses get the same key as their plain IPv4 address.
+func ipKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+const (
+	counterDatagramsReceived counter = iota
+	counterDatagramsSent
+	counterBytesReceived
+	counterBytesSent
+	counterACKsReceived
+	counterACKsSent
+	counterNAKsReceived
+	counterNAKsSent
+	counterRetransmissions
+	counterSplitReassemblies
+	counterDropped
+	counterHandshakeFailures
+	counterCount
+)
+
+// counter is the index of a counter in a set of counters.
+type counter int
+
+// counters is a set of counters that is safe for concurrent use.
+type counters [counterCount]uint64
+
+// add atomically adds delta to a counter.
+func (counters *counters) add(counter counter, delta int) {
+	atomic.AddUint64(&counters[counter], uint64(delta))
+}
+
+// snapshot returns a snapshot of all counters.
+func (counters *counters) snapshot() Counters {
+	var values [counterCount]uint64
+	for i := range counters {
+		values[i] = atomic.LoadUint64(&counters[i])
+	}
+	return Counters{values[counterDatagramsReceived], values[counterDatagramsSent],
+		values[counterBytesReceived], values[counterBytesSent],
+		values[counterACKsReceived], values[counterACKsSent],
+		values[counterNAKsReceived], values[counterNAKsSent],
+		values[counterRetransmissions], values[counterSplitReassemblies],
+		values[counterDropped], values[counterHandshakeFailures]}
+}
+
+// Counters is a snapshot of the traffic counters of either a manager or a single session.
+// The counters of a manager hold the totals of all of its sessions, including sessions already closed,
+// and also count traffic of addresses without a session.
+type Counters struct {
+	// DatagramsReceived is the amount of datagrams received.
+	DatagramsReceived uint64
+	// DatagramsSent is the amount of datagrams sent, including retransmissions.
+	DatagramsSent uint64
+	// BytesReceived is the amount of bytes received, including ACKs, NAKs and unconnected packets.
+	BytesReceived uint64
+	// BytesSent is the amount of bytes sent, including ACKs, NAKs and unconnected packets.
+	BytesSent uint64
+	// ACKsReceived is the amount of ACK packets received.
+	ACKsReceived uint64
+	// ACKsSent is the amount of ACK packets sent.
+	ACKsSent uint64
+	// NAKsReceived is the amount of NAK packets received.
+	NAKsReceived uint64
+	// NAKsSent is the amount of NAK packets sent.
+	NAKsSent uint64
+	// Retransmissions is the amount of datagrams retransmitted, either because they were NAKed
+	// or because their retransmission timeout expired.
+	Retransmissions uint64
+	// SplitReassemblies is the amount of split packets reassembled from their fragments.
+	SplitReassemblies uint64
+	// Dropped is the amount of packets dropped. Packets of blocked addresses, packets exceeding the rate limit,
+	// malformed packets, packets of addresses without a session, datagrams received while the receive window
+	// is full and duplicate encapsulated packets are dropped.
+	Dropped uint64
+	// HandshakeFailures is the amount of open connection requests that were rejected,
+	// because of an incompatible protocol, an invalid cookie, a banned address or no free incoming connections.
+	// Handshake failures are only counted by the manager.
+	HandshakeFailures uint64
+}
+
+// SessionStats is a snapshot of the statistics of a single session.
+type SessionStats struct {
+	Counters
+	// Address is the UDP address of the session.
+	Address string
+	// ClientId is the client ID of the session, which is 0 until the connection request is handled.
+	ClientId uint64
+	// RTT holds the round trip time statistics of the session.
+	RTT RTTStats
+	// QueueDepths holds the amount of encapsulated packets in every priority queue, indexed by the priority.
+	QueueDepths [4]int
+	// BytesInFlight is the amount of bytes sent that have not yet been acknowledged.
+	BytesInFlight int
+	// CongestionWindow is the current congestion window of the session in bytes.
+	CongestionWindow int
+}
+
+// Stats is a snapshot of the statistics of a manager and all of its sessions.
+type Stats struct {
+	Counters
+	// BlockedIPs is the amount of IP addresses currently blocked.
+	BlockedIPs int
+	// Sessions holds the statistics of every session of the manager.
+	Sessions []SessionStats
+}
+
+// Stats returns a snapshot of the statistics of the session.
+func (session *Session) Stats() SessionStats {
+	stats := SessionStats{Counters: session.counters.snapshot(), Address: session.UDPAddr.String(), ClientId: session.GetClientId()}
+	stats.RTT = session.RTT()
+	stats.QueueDepths = [4]int{len(*session.Queues.Immediate), len(*session.Queues.High), len(*session.Queues.Medium), len(*session.Queues.Low)}
+	stats.BytesInFlight = session.RecoveryQueue.BytesInFlight()
+	stats.CongestionWindow = session.CongestionController.Window()
+	return stats
+}
+
+// Stats returns a snapshot of the statistics of the manager and all of its sessions.
+func (manager *Manager) Stats() Stats {
+	now := manager.Clock.Now()
+	blocked := 0
+	manager.RLock()
+	for _, block := range manager.ipBlocks {
+		if now.Before(block.until) {
+			blocked++
+		}
+	}
+	manager.RUnlock()
+	stats := Stats{Counters: manager.counters.snapshot(), BlockedIPs: blocked}
+	for _, session := range manager.Sessions.GetSessions() {
+		stats.Sessions = append(stats.Sessions, session.Stats())
+	}
+	return stats
+}
+
+// count adds delta to a counter of both the session and its manager.
+func (session *Session) count(counter counter, delta int) {
+	session.counters.add(counter, delta)
+	session.Manager.counters.add(counter, delta)
+}
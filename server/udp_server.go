@@ -2,14 +2,18 @@ package server
 
 import (
 	"net"
+	"net/netip"
 	"errors"
+	"strconv"
 )
 
-// UDPServer is a wrapper around a UDPConn.
-// It can be started on a given address and port,
+// UDPServer is a wrapper around a packet connection.
+// It can be started on a given address and port, or be given any net.PacketConn,
 // and provides functions to read and write packets to the connection.
+// Packet connections other than UDP connections must read addresses that are either a *net.UDPAddr,
+// or of which the string is of the form "host:port", and must accept a *net.UDPAddr to write to.
 type UDPServer struct {
-	*net.UDPConn
+	net.PacketConn
 }
 
 // NotStarted is an error returned for the Read and Write functions if the server has not yet been started.
@@ -25,35 +29,78 @@ func NewUDPServer() *UDPServer {
 // Start starts the UDP server on the given address and port.
 // An error is returned if ListenUDP is not successful.
 // Actions can be used on the UDP server once started.
+// Empty and unspecified addresses, such as "0.0.0.0" and "::", listen on both IPv4 and IPv6.
+// Any other address only listens on the IP version of that address.
 func (server *UDPServer) Start(address string, port int) error {
-	addr := &net.UDPAddr{IP: net.ParseIP(address), Port: port}
-	var err error
-	server.UDPConn, err = net.ListenUDP("udp", addr)
-	return err
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	network := "udp"
+	if addr.IP.IsUnspecified() {
+		addr.IP = nil
+	} else if addr.IP.To4() != nil {
+		network = "udp4"
+	} else if addr.IP != nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return err
+	}
+	server.PacketConn = conn
+	return nil
 }
 
 // HasStarted checks if a UDPServer has been started.
 // No actions can be executed on the UDPServer while not started.
 func (server *UDPServer) HasStarted() bool {
-	return server.UDPConn != nil
+	return server.PacketConn != nil
 }
 
-// Read reads any data from the UDP connection into the given byte array.
+// Read reads any data from the packet connection into the given byte array.
 // The IP address and port of the client that sent the data will be returned,
 // along with an error that might have occurred during reading.
+// An error is returned if the address read is not a UDP address, nor of the form "host:port".
 func (server *UDPServer) Read(buffer []byte) (bytesRead int, addr *net.UDPAddr, err error) {
 	if !server.HasStarted() {
 		return 0, nil, NotStarted
 	}
-	bytesRead, addr, err = server.UDPConn.ReadFromUDP(buffer)
+	bytesRead, sender, err := server.PacketConn.ReadFrom(buffer)
+	if err != nil {
+		return bytesRead, nil, err
+	}
+	addr, err = toUDPAddr(sender)
 	return
 }
 
-// Write writes a byte array to a UDP connection.
+// Write writes a byte array to the packet connection.
 // Write returns the amount of bytes written and an error that might have occurred.
 func (server *UDPServer) Write(buffer []byte, addr *net.UDPAddr) (int, error) {
 	if !server.HasStarted() {
 		return 0, NotStarted
 	}
-	return server.UDPConn.WriteToUDP(buffer, addr)
-}
\ No newline at end of file
+	return server.PacketConn.WriteTo(buffer, addr)
+}
+
+// Close closes the packet connection of the server.
+// Any blocked Read call is unblocked and returns an error.
+func (server *UDPServer) Close() error {
+	if !server.HasStarted() {
+		return NotStarted
+	}
+	return server.PacketConn.Close()
+}
+
+// toUDPAddr returns the UDP address of an address read from a packet connection.
+// Addresses other than UDP addresses are parsed from their string.
+func toUDPAddr(addr net.Addr) (*net.UDPAddr, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr, nil
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return net.UDPAddrFromAddrPort(addrPort), nil
+}
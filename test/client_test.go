@@ -0,0 +1,175 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestDial(t *testing.T) {
+	manager := server.NewManager()
+	connected := make(chan *server.Session, 1)
+	manager.ConnectFunction = func(session *server.Session) {
+		connected <- session
+	}
+	if err := manager.Start("127.0.0.1", 19140); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	session, err := client.Dialer{Timeout: time.Second * 5}.Dial("127.0.0.1:19140")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.FlagForClose()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("server never received the new incoming connection")
+	}
+}
+
+// datagramDropper is a packet connection that drops all datagrams read, so that only the unconnected part
+// of the handshake completes.
+type datagramDropper struct {
+	net.PacketConn
+}
+
+func (conn datagramDropper) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := conn.PacketConn.ReadFrom(buffer)
+		if err != nil || n == 0 || buffer[0]&0x80 == 0 {
+			return n, addr, err
+		}
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	network := loopback.NewNetwork()
+	serverConn, err := network.ListenPacket("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := server.NewManager()
+	manager.Serve(datagramDropper{serverConn})
+	defer manager.Stop()
+
+	clientConn, err := network.ListenPacket("10.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnected := false
+	var reason server.DisconnectReason
+	dialer := client.Dialer{Timeout: time.Second, DisconnectFunction: func(session *server.Session, disconnectReason server.DisconnectReason) {
+		disconnected, reason = true, disconnectReason
+	}}
+	if _, err := dialer.DialPacketConn(clientConn, "10.0.0.1:19132"); err != client.TimedOut {
+		t.Fatal("expected the dial to time out, got", err)
+	}
+	if !disconnected || reason != server.DisconnectReasonTimeout {
+		t.Fatal("pending session was not closed with the timeout reason:", disconnected, reason)
+	}
+}
+
+func TestDialDualStack(t *testing.T) {
+	manager := server.NewManager()
+	connected := make(chan *server.Session, 2)
+	manager.ConnectFunction = func(session *server.Session) {
+		connected <- session
+	}
+	if err := manager.Start("::", 19141); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	for _, address := range []string{"127.0.0.1:19141", "[::1]:19141"} {
+		session, err := client.Dialer{Timeout: time.Second * 5}.Dial(address)
+		if err != nil {
+			t.Fatal(address, err)
+		}
+		defer session.FlagForClose()
+
+		select {
+		case serverSession := <-connected:
+			if _, ok := manager.Sessions.GetSession(serverSession.UDPAddr); !ok {
+				t.Fatal("session of", address, "could not be found by its address")
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("server never received the new incoming connection of", address)
+		}
+	}
+}
+
+type rawPacket []byte
+
+func (packet rawPacket) Encode() {}
+
+func (packet rawPacket) GetBuffer() []byte {
+	return packet
+}
+
+func TestDeliveryReceipt(t *testing.T) {
+	manager := server.NewManager()
+	received := make(chan []byte, 1)
+	manager.PacketFunction = func(packet []byte, session *server.Session) {
+		received <- packet
+	}
+	if err := manager.Start("127.0.0.1", 19142); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	session, err := client.Dialer{Timeout: time.Second * 5}.Dial("127.0.0.1:19142")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.FlagForClose()
+
+	// The packet is larger than the MTU size, so that the receipt covers several split packets.
+	packet := make(rawPacket, 4000)
+	packet[0] = 0xfe
+	receipt := session.SendPacket(packet, protocol.ReliabilityReliableOrderedWithAck, server.PriorityMedium, 0)
+	if receipt == nil {
+		t.Fatal("no receipt was returned for a WithAck reliability")
+	}
+	select {
+	case <-receipt.Done():
+		if !receipt.Acknowledged() {
+			t.Fatal("packet was considered lost")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("receipt was never done")
+	}
+	if buffer := <-received; len(buffer) != len(packet) {
+		t.Fatal("expected a packet of", len(packet), "bytes, got", len(buffer))
+	}
+	if session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0) != nil {
+		t.Fatal("a receipt was returned for a reliability without ACK")
+	}
+}
+
+func TestIncompatibleProtocol(t *testing.T) {
+	manager := server.NewManager()
+	manager.SupportedProtocols = []byte{10}
+	if err := manager.Start("127.0.0.1", 19145); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	_, err := client.Dialer{Timeout: time.Second * 5, Protocol: 9}.Dial("127.0.0.1:19145")
+	incompatible, ok := err.(*client.IncompatibleProtocolError)
+	if !ok {
+		t.Fatal("expected an incompatible protocol error, got", err)
+	}
+	if incompatible.Protocol != 10 {
+		t.Fatal("expected the server to reply with protocol 10, got", incompatible.Protocol)
+	}
+	if manager.Sessions.Count() != 0 {
+		t.Fatal("a session was created for an incompatible client")
+	}
+}
@@ -0,0 +1,29 @@
+package test
+
+import (
+	"testing"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	window := server.NewSlidingWindow(1000)
+	if window.Window() != 10000 {
+		t.Fatal("expected an initial window of 10000 bytes, got", window.Window())
+	}
+	window.OnAcknowledged(4000, server.RTTStats{})
+	if window.Window() != 14000 {
+		t.Fatal("window did not grow by the bytes acknowledged in slow start:", window.Window())
+	}
+	window.OnLoss(false, server.RTTStats{})
+	if window.Window() != 7000 {
+		t.Fatal("window was not halved after a NAK:", window.Window())
+	}
+	window.OnAcknowledged(7000, server.RTTStats{})
+	if window.Window() != 8000 {
+		t.Fatal("window did not grow by one MTU size per window in congestion avoidance:", window.Window())
+	}
+	window.OnLoss(true, server.RTTStats{})
+	if window.Window() != 2000 {
+		t.Fatal("window did not collapse after a retransmission timeout:", window.Window())
+	}
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+)
+
+func TestSecurityCookies(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	serverPeer.manager.Security = true
+
+	// A request with a forged cookie must not create a session.
+	conn, err := harness.Network.ListenPacket("10.0.0.3:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := protocol.NewOpenConnectionRequest2()
+	request.Security = true
+	request.Cookie = 0xdeadbeef
+	request.ServerAddress = "10.0.0.1"
+	request.ServerPort = 19132
+	request.MtuSize = 1400
+	request.ClientId = 1
+	request.Encode()
+	conn.WriteTo(request.Buffer, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132})
+	harness.Step()
+	if serverPeer.manager.Sessions.Count() != 0 || serverPeer.manager.Stats().HandshakeFailures != 1 {
+		t.Fatal("a request with a forged cookie was not rejected")
+	}
+
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+		t.Fatal(err)
+	}
+	if serverPeer.manager.Sessions.Count() != 1 {
+		t.Fatal("expected 1 session after dialing with a valid cookie, got", serverPeer.manager.Sessions.Count())
+	}
+}
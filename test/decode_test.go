@@ -0,0 +1,97 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	request := protocol.NewOpenConnectionRequest1()
+	request.MtuSize = 576
+	request.Encode()
+	badMagic := append([]byte{}, request.Buffer...)
+	badMagic[1] = 0xff
+
+	split := protocol.NewEncapsulatedPacket()
+	split.HasSplit = true
+	split.SplitCount = 2
+	split.SplitIndex = 2
+	split.Buffer = []byte{0xfe}
+	datagram := protocol.NewDatagram()
+	datagram.AddPacket(split)
+	datagram.Encode()
+
+	packet := protocol.NewEncapsulatedPacket()
+	packet.Reliability = protocol.ReliabilityReliableOrdered
+	packet.Buffer = []byte{0xfe, 0x01}
+	truncated := protocol.NewDatagram()
+	truncated.AddPacket(packet)
+	truncated.Encode()
+
+	tests := []struct {
+		name   string
+		packet protocol.IPacket
+		buffer []byte
+		err    error
+	}{
+		{"empty connected ping", protocol.NewConnectedPing(), []byte{}, protocol.ErrShortBuffer},
+		{"short connected pong", protocol.NewConnectedPong(), []byte{protocol.IdConnectedPong, 0, 0}, protocol.ErrShortBuffer},
+		{"short open connection request 1", protocol.NewOpenConnectionRequest1(), request.Buffer[:10], protocol.ErrShortBuffer},
+		{"invalid magic", protocol.NewOpenConnectionRequest1(), badMagic, protocol.ErrInvalidMagic},
+		{"invalid address", protocol.NewNewIncomingConnection(), []byte{protocol.IdNewIncomingConnection, 5}, protocol.ErrInvalidAddress},
+		{"short datagram", protocol.NewDatagram(), []byte{0x84, 0}, protocol.ErrShortBuffer},
+		{"truncated encapsulated packet", protocol.NewDatagram(), truncated.Buffer[:len(truncated.Buffer)-1], protocol.ErrShortBuffer},
+		{"invalid split", protocol.NewDatagram(), datagram.Buffer, protocol.ErrInvalidSplit},
+		{"empty encapsulated packet", protocol.NewDatagram(), []byte{0x84, 0, 0, 0, 0, 0, 0}, protocol.ErrEmptyPacket},
+		{"invalid acknowledgement range", protocol.NewACK(), []byte{protocol.FlagDatagramAck, 0, 1, 0, 5, 0, 0, 1, 0, 0}, protocol.ErrInvalidRange},
+		{"short acknowledgement", protocol.NewACK(), []byte{protocol.FlagDatagramAck, 0, 2, 1, 5, 0, 0}, protocol.ErrShortBuffer},
+	}
+	for _, test := range tests {
+		test.packet.SetBuffer(test.buffer)
+		if err := test.packet.Decode(); err != test.err {
+			t.Error(test.name, "decoded with error", err, "instead of", test.err)
+		}
+	}
+}
+
+func TestMalformedPacketPolicy(t *testing.T) {
+	harness := loopback.NewHarness()
+	manager, err := harness.NewManager("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := harness.Network.ListenPacket("10.0.0.2:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteTo([]byte{protocol.IdOpenConnectionRequest1, 0x00, 0xff}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132})
+	harness.Step()
+
+	if !manager.IsIPBlocked(conn.LocalAddr().(*net.UDPAddr)) {
+		t.Fatal("sender of a malformed packet was not blocked")
+	}
+}
+
+func TestConnectionRequestSecurity(t *testing.T) {
+	request := protocol.NewConnectionRequest()
+	request.ClientId = 0x7a3c9e1f5d2b8406
+	request.PingSendTime = 1000
+	request.Security = 1
+	request.Encode()
+
+	decoded := protocol.NewConnectionRequest()
+	decoded.SetBuffer(request.Buffer)
+	if err := decoded.Decode(); err != nil || decoded.Security != 1 || decoded.ClientId != request.ClientId {
+		t.Fatal("connection request decoded with security", decoded.Security, "and error", err)
+	}
+	// The security byte is left out by some clients.
+	decoded = protocol.NewConnectionRequest()
+	decoded.SetBuffer(request.Buffer[:len(request.Buffer)-1])
+	if err := decoded.Decode(); err != nil || decoded.Security != 0 || decoded.PingSendTime != request.PingSendTime {
+		t.Fatal("connection request without security byte decoded with error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestDisconnect(t *testing.T) {
+	manager := server.NewManager()
+	manager.ConnectFunction = func(session *server.Session) {
+		session.Disconnect(server.DisconnectReasonKicked)
+	}
+	disconnected := make(chan server.DisconnectReason, 1)
+	manager.DisconnectFunction = func(session *server.Session, reason server.DisconnectReason) {
+		disconnected <- reason
+	}
+	if err := manager.Start("127.0.0.1", 19144); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	clientDisconnected := make(chan server.DisconnectReason, 1)
+	_, err := client.Dialer{Timeout: time.Second * 5, DisconnectFunction: func(session *server.Session, reason server.DisconnectReason) {
+		clientDisconnected <- reason
+	}}.Dial("127.0.0.1:19144")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reasons := range []struct {
+		side     string
+		channel  chan server.DisconnectReason
+		expected server.DisconnectReason
+	}{
+		{"server", disconnected, server.DisconnectReasonKicked},
+		{"client", clientDisconnected, server.DisconnectReasonClientQuit},
+	} {
+		select {
+		case reason := <-reasons.channel:
+			if reason != reasons.expected {
+				t.Fatal(reasons.side, "session disconnected with reason", reason, "instead of", reasons.expected)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal(reasons.side, "session was never disconnected")
+		}
+	}
+	if manager.Sessions.Count() != 0 {
+		t.Fatal("kicked session was not removed")
+	}
+}
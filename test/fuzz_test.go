@@ -0,0 +1,200 @@
+package test
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+// offlineMagic is the magic of unconnected messages, as it is written on the wire.
+const offlineMagic = "00ffff00fefefefefdfdfdfd12345678"
+
+// hexBytes decodes a hexadecimal string, in which spaces are ignored.
+func hexBytes(s string) []byte {
+	buffer, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return buffer
+}
+
+// clientPackets returns the packets a Minecraft client sends to connect to a server, in the order it sends them,
+// followed by packets it sends once connected. The packets follow the wire format of the client byte for byte,
+// and are used as the seed corpus of all fuzz targets.
+func clientPackets() [][]byte {
+	// Open connection requests 1 are padded to the MTU size tried, which is 1492, 1200 and 576.
+	request1 := func(mtuSize int) []byte {
+		request := hexBytes("05" + offlineMagic + "0b")
+		return append(request, make([]byte, mtuSize-28-len(request))...)
+	}
+	// New incoming connections hold the address of the server, followed by 20 internal addresses of the client.
+	newIncomingConnection := hexBytes("13 04 80ffff fe 4abc")
+	for i := 0; i < 20; i++ {
+		newIncomingConnection = append(newIncomingConnection, hexBytes("04 ffffffff 0000")...)
+	}
+	newIncomingConnection = append(newIncomingConnection, hexBytes("00000000000003e8 00000000000003f2")...)
+	newIncomingConnectionDatagram := append(hexBytes("84 010000 60 0520 010000 000000 00"), newIncomingConnection...)
+
+	return [][]byte{
+		// Unconnected ping: ping time, magic and client GUID.
+		hexBytes("01 0000000000a1b2c3" + offlineMagic + "7a3c9e1f5d2b8406"),
+		request1(server.MaximumMTUSize),
+		request1(1200),
+		request1(576),
+		// Open connection request 2 for 127.0.0.1:19132, with MTU size 1492 and the client GUID.
+		hexBytes("07" + offlineMagic + "04 80fffffe 4abc 05d4 7a3c9e1f5d2b8406"),
+		// Open connection request 2 for [::1]:19132.
+		hexBytes("07" + offlineMagic + "06 1700 4abc 00000000 00000000000000000000000000000001 00000000 05d4 7a3c9e1f5d2b8406"),
+		// Connection request: client GUID, ping time and security, sent reliably.
+		hexBytes("84 000000 40 0090 000000 09 7a3c9e1f5d2b8406 00000000000003e8 00"),
+		newIncomingConnectionDatagram,
+		// Connected ping, sent unreliably.
+		hexBytes("84 020000 00 0048 00 00000000000007d0"),
+		// First fragment of a game packet split into two, sent reliable ordered.
+		hexBytes("8c 030000 70 0020 020000 010000 00 00000002 0001 00000000 fe78dacb"),
+		// ACK of a single datagram, and of a range of datagrams.
+		hexBytes("c0 0001 01 000000"),
+		hexBytes("c0 0001 00 000000 050000"),
+		// NAK of two datagrams.
+		hexBytes("a0 0002 01 020000 01 040000"),
+	}
+}
+
+// addSeeds adds the client packets to the seed corpus of the fuzz target.
+func addSeeds(f *testing.F) {
+	for _, packet := range clientPackets() {
+		f.Add(packet)
+	}
+}
+
+func FuzzUnconnectedPing(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		ping := protocol.NewUnconnectedPing()
+		ping.SetBuffer(buffer)
+		if err := ping.Decode(); err == nil && !ping.HasValidMagic() {
+			t.Fatal("ping decoded without valid magic")
+		}
+	})
+}
+
+func FuzzOpenConnectionRequest1(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		request := protocol.NewOpenConnectionRequest1()
+		request.SetBuffer(buffer)
+		if err := request.Decode(); err == nil && int(request.MtuSize) != int(int16(len(buffer)+28)) {
+			t.Fatal("MTU size", request.MtuSize, "decoded from request of", len(buffer), "bytes")
+		}
+	})
+}
+
+func FuzzOpenConnectionRequest2(f *testing.F) {
+	for _, packet := range clientPackets() {
+		f.Add(packet, false)
+		f.Add(packet, true)
+	}
+	f.Fuzz(func(t *testing.T, buffer []byte, security bool) {
+		request := protocol.NewOpenConnectionRequest2()
+		request.Security = security
+		request.SetBuffer(buffer)
+		if err := request.Decode(); err == nil && net.ParseIP(strings.SplitN(request.ServerAddress, "%", 2)[0]) == nil {
+			t.Fatal("request decoded with invalid server address", request.ServerAddress)
+		}
+	})
+}
+
+func FuzzConnectionRequest(f *testing.F) {
+	addSeeds(f)
+	// The connection request itself, without the headers of the datagram and encapsulated packet.
+	f.Add(hexBytes("09 7a3c9e1f5d2b8406 00000000000003e8 00"))
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		request := protocol.NewConnectionRequest()
+		request.SetBuffer(buffer)
+		request.Decode()
+	})
+}
+
+func FuzzDatagram(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		datagram := protocol.NewDatagram()
+		datagram.SetBuffer(buffer)
+		if err := datagram.Decode(); err != nil {
+			return
+		}
+		if datagram.SequenceNumber >= 1<<24 {
+			t.Fatal("sequence number", datagram.SequenceNumber, "exceeds a triad")
+		}
+		for _, packet := range *datagram.GetPackets() {
+			if packet.Length == 0 || int(packet.Length) != len(packet.Buffer) {
+				t.Fatal("encapsulated packet of length", packet.Length, "decoded with", len(packet.Buffer), "bytes")
+			}
+			if packet.HasSplit && packet.SplitIndex >= packet.SplitCount {
+				t.Fatal("split index", packet.SplitIndex, "decoded for split count", packet.SplitCount)
+			}
+		}
+	})
+}
+
+func FuzzEncapsulatedPacket(f *testing.F) {
+	for _, packet := range clientPackets() {
+		if packet[0]&protocol.BitFlagValid != 0 && len(packet) > 4 {
+			// Encapsulated packets start after the flags and sequence number of the datagram.
+			f.Add(packet[4:])
+		}
+	}
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		stream := protocol.NewDatagram()
+		stream.SetBuffer(buffer)
+		for stream.Offset < len(buffer) {
+			offset := stream.Offset
+			packet, err := protocol.NewEncapsulatedPacket().GetFromBinary(stream)
+			if err != nil {
+				return
+			}
+			if read := stream.Offset - offset; read != packet.GetLength() {
+				t.Fatal("encapsulated packet of length", packet.GetLength(), "read", read, "bytes")
+			}
+		}
+	})
+}
+
+func FuzzAcknowledgementPacket(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		for _, packet := range []*protocol.AcknowledgementPacket{protocol.NewACK().AcknowledgementPacket, protocol.NewNAK().AcknowledgementPacket} {
+			packet.SetBuffer(buffer)
+			if err := packet.Decode(); err != nil {
+				continue
+			}
+			// Decoding stops after 4096 sequence numbers, once the range being decoded ends.
+			if len(packet.Packets) > 4096+513 {
+				t.Fatal(len(packet.Packets), "sequence numbers decoded")
+			}
+		}
+	})
+}
+
+// FuzzHandlePacket fuzzes the packets handled by a manager, both for an address with a session and without one,
+// so that packets are decoded by the same decoder the manager selects for them.
+func FuzzHandlePacket(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		harness := loopback.NewHarness()
+		manager, err := harness.NewManager("10.0.0.1:19132")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 19132}
+		manager.Sessions.AddSession(server.NewSession(addr, server.MaximumMTUSize, manager))
+
+		manager.HandlePacket(append([]byte{}, buffer...), addr)
+		manager.HandlePacket(append([]byte{}, buffer...), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3).To4(), Port: 19132})
+		harness.Step()
+	})
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/impair"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+// badNetwork returns the config of a network losing 10% of its packets,
+// which also reorders, duplicates and delays packets.
+func badNetwork(seed int64) impair.Config {
+	return impair.Config{
+		Loss:      0.1,
+		BurstLoss: 0.01,
+		Duplicate: 0.05,
+		Reorder:   0.05,
+		Latency:   time.Millisecond * 40,
+		Jitter:    time.Millisecond * 30,
+		Bandwidth: 200000,
+		Seed:      seed,
+	}
+}
+
+// impairedPackets returns packets of increasing sizes, of which the larger ones need to be split.
+func impairedPackets(count int) []rawPacket {
+	packets := make([]rawPacket, count)
+	for i := range packets {
+		packets[i] = make(rawPacket, 1+(i*337)%4000)
+		packets[i][0] = 0xfe
+		for j := 1; j < len(packets[i]); j++ {
+			packets[i][j] = byte(i + j)
+		}
+	}
+	return packets
+}
+
+func TestImpairedHarness(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	serverConn := harness.Impair(serverPeer.manager, badNetwork(1))
+	clientConn := harness.Impair(clientPeer.manager, badNetwork(2))
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+		t.Fatal(err)
+	}
+	if !harness.RunUntil(func() bool { return serverPeer.session != nil }, time.Second * 5) {
+		t.Fatal("server never received the new incoming connection")
+	}
+
+	packets := impairedPackets(200)
+	for i, packet := range packets {
+		clientPeer.session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+		serverPeer.session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+		// At most 20 packets are in flight, so that the send queues of the sessions never fill up.
+		harness.RunUntil(func() bool {
+			return len(serverPeer.packets) > i-20 && len(clientPeer.packets) > i-20
+		}, time.Second * 5)
+	}
+	if !harness.RunUntil(func() bool {
+		return len(serverPeer.packets) == len(packets) && len(clientPeer.packets) == len(packets)
+	}, time.Second * 30) {
+		t.Fatal("packets were not received over the bad network:", len(serverPeer.packets), len(clientPeer.packets))
+	}
+	for i, packet := range packets {
+		if !bytes.Equal(serverPeer.packets[i], packet) || !bytes.Equal(clientPeer.packets[i], packet) {
+			t.Fatal("packet", i, "was not received intact and in order")
+		}
+	}
+	if serverPeer.closed || clientPeer.closed {
+		t.Fatal("sessions did not survive the bad network")
+	}
+	for _, conn := range []*impair.Conn{serverConn, clientConn} {
+		if stats, _ := conn.Stats(); stats.Lost == 0 || stats.Duplicated == 0 || stats.Reordered == 0 {
+			t.Fatal("packets were not impaired:", stats)
+		}
+	}
+}
+
+func TestImpairedBlackhole(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	harness.Impair(serverPeer.manager, impair.Config{MTU: 1300})
+	clientConn := harness.Impair(clientPeer.manager, impair.Config{MTU: 1300})
+	session, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.MTUSize > 1300 {
+		t.Fatal("MTU size", session.MTUSize, "was agreed on over a path with an MTU of 1300")
+	}
+	if stats, _ := clientConn.Stats(); stats.Blackholed == 0 {
+		t.Fatal("no requests were dropped by the MTU black hole")
+	}
+
+	large := make(rawPacket, 5000)
+	large[0] = 0xfe
+	session.SendPacket(large, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 1 }, time.Second * 2) {
+		t.Fatal("split packet was not received over the path")
+	}
+}
+
+// lossyNetwork returns the config of a network losing 10% of its packets randomly.
+func lossyNetwork(seed int64) impair.Config {
+	return impair.Config{Loss: 0.1, Latency: time.Millisecond * 20, Jitter: time.Millisecond * 10, Seed: seed}
+}
+
+func TestImpairedConn(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	harness.Impair(serverPeer.manager, lossyNetwork(3))
+	harness.Impair(clientPeer.manager, lossyNetwork(4))
+	session, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packets := impairedPackets(30)
+	for _, packet := range packets {
+		session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+	}
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == len(packets) }, time.Second * 10) {
+		t.Fatal("packets were not received over the lossy network:", len(serverPeer.packets))
+	}
+	for i, packet := range packets {
+		if !bytes.Equal(serverPeer.packets[i], packet) {
+			t.Fatal("packet", i, "was not received intact and in order")
+		}
+	}
+	if serverPeer.closed || clientPeer.closed {
+		t.Fatal("sessions did not survive the lossy network")
+	}
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestMaxConnections(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	serverPeer.manager.MaxConnections = 2
+	serverPeer.manager.MaxConnectionsPerIP = 1
+
+	// dial dials the server from a new manager listening on the given address.
+	dial := func(address string) error {
+		_, err := harness.Dial(newPeer(t, harness, address).manager, "10.0.0.1:19132")
+		return err
+	}
+	if err := dial("10.0.0.2:19133"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial("10.0.0.2:19134"); err != client.ServerFull {
+		t.Fatal("expected a second connection of the same IP to fail with ServerFull, got", err)
+	}
+	if err := dial("10.0.0.3:19133"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial("10.0.0.4:19133"); err != client.ServerFull {
+		t.Fatal("expected a third connection to fail with ServerFull, got", err)
+	}
+	if count := serverPeer.manager.Sessions.CountIP(net.ParseIP("10.0.0.2")); count != 1 {
+		t.Fatal("expected 1 session of 10.0.0.2, got", count)
+	}
+}
+
+func TestConnectionBanned(t *testing.T) {
+	manager := server.NewManager()
+	if err := manager.Start("127.0.0.1", 19147); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+	manager.BlockIP(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, time.Minute)
+
+	if _, err := (client.Dialer{Timeout: time.Second * 5}).Dial("127.0.0.1:19147"); err != client.Banned {
+		t.Fatal("expected a blocked IP to fail with Banned, got", err)
+	}
+	if manager.Sessions.Count() != 0 {
+		t.Fatal("a session was created for a blocked IP")
+	}
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestListener(t *testing.T) {
+	listener, err := server.Listen("udp", "127.0.0.1:19153")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ net.Listener = listener
+
+	received := make(chan []byte, 1)
+	session, err := client.Dialer{Timeout: time.Second * 5, PacketFunction: func(packet []byte, session *server.Session) {
+		received <- packet
+	}}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().(*net.UDPAddr).Port != session.Manager.Server.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatal("remote address", conn.RemoteAddr(), "is not the address of the client", session.Manager.Server.LocalAddr())
+	}
+
+	session.SendPacket(rawPacket{0xfe, 1, 2, 3}, protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer[:n], []byte{0xfe, 1, 2, 3}) {
+		t.Fatal("read message", buffer[:n])
+	}
+
+	if _, err := conn.Write([]byte{0xfe, 4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case packet := <-received:
+		if !bytes.Equal(packet, []byte{0xfe, 4, 5, 6}) {
+			t.Fatal("client received message", packet)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("client never received the written message")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	if _, err := conn.Read(buffer); err == nil {
+		t.Fatal("read did not time out")
+	} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatal("read returned", err, "instead of a timeout")
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	session.Disconnect(server.DisconnectReasonClientQuit)
+	if _, err := conn.Read(buffer); err != io.EOF {
+		t.Fatal("read returned", err, "instead of io.EOF after the client disconnected")
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("accept returned", err, "after closing the listener")
+	}
+}
+
+func TestListenerBacklog(t *testing.T) {
+	harness := loopback.NewHarness()
+	manager, err := harness.NewManager("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := server.NewListener(manager)
+
+	var clients []*peer
+	for i := 0; i <= server.ListenerBacklog; i++ {
+		clientPeer := newPeer(t, harness, fmt.Sprintf("10.0.1.%v:0", i))
+		if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, clientPeer)
+	}
+	// The session connecting once the backlog is full is disconnected, instead of blocking the manager.
+	if !harness.RunUntil(func() bool { return clients[server.ListenerBacklog].closed }, time.Second * 2) {
+		t.Fatal("session connecting while the backlog was full was not disconnected")
+	}
+	for i := 0; i < server.ListenerBacklog; i++ {
+		if _, err := listener.Accept(); err != nil || clients[i].closed {
+			t.Fatal("session", i, "in the backlog was not accepted:", err)
+		}
+	}
+}
+
+func TestListenerWriteDeadline(t *testing.T) {
+	harness := loopback.NewHarness()
+	manager, err := harness.NewManager("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := server.NewListener(manager)
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+		t.Fatal(err)
+	}
+	harness.Run(time.Millisecond * 100)
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The harness is not stepped, so that the send queue fills up and Write times out instead of blocking.
+	conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 50))
+	written := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := conn.Write([]byte{0xfe}); err != nil {
+				written <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-written:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("write to a full queue returned", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("write to a full queue blocked past the deadline")
+	}
+}
+
+func TestListenerUnreadBacklog(t *testing.T) {
+	listener, err := server.Listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan server.DisconnectReason, 1)
+	dialer := client.Dialer{Timeout: time.Second * 5, DisconnectFunction: func(session *server.Session, reason server.DisconnectReason) {
+		disconnected <- reason
+	}}
+	session, err := dialer.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.FlagForClose()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is never read from, so that the session gets disconnected once the backlog is full.
+	for i := 0; i < server.ConnBacklog*2; i++ {
+		session.SendPacket(rawPacket{0xfe, byte(i)}, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("session was not disconnected once the backlog was full")
+	}
+	if err := listener.Close(); err != nil {
+		t.Fatal("listener with unread messages did not close:", err)
+	}
+}
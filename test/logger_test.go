@@ -0,0 +1,100 @@
+package test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/server"
+)
+
+// recordHandler is a slog handler that keeps all records logged to it.
+type recordHandler struct {
+	mutex   *sync.Mutex
+	records *[]slog.Record
+	attrs   []slog.Attr
+}
+
+func (handler recordHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (handler recordHandler) Handle(ctx context.Context, record slog.Record) error {
+	record = record.Clone()
+	record.AddAttrs(handler.attrs...)
+	handler.mutex.Lock()
+	*handler.records = append(*handler.records, record)
+	handler.mutex.Unlock()
+	return nil
+}
+
+func (handler recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler.attrs = append(append([]slog.Attr{}, handler.attrs...), attrs...)
+	return handler
+}
+
+func (handler recordHandler) WithGroup(name string) slog.Handler {
+	return handler
+}
+
+// find returns the attributes of the first record with the given message.
+func (handler recordHandler) find(message string) (map[string]slog.Value, bool) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	for _, record := range *handler.records {
+		if record.Message != message {
+			continue
+		}
+		attrs := make(map[string]slog.Value)
+		record.Attrs(func(attr slog.Attr) bool {
+			attrs[attr.Key] = attr.Value
+			return true
+		})
+		return attrs, true
+	}
+	return nil, false
+}
+
+func TestLogger(t *testing.T) {
+	handler := recordHandler{mutex: &sync.Mutex{}, records: &[]slog.Record{}}
+	manager := server.NewManager()
+	manager.Logger = slog.New(handler)
+	disconnected := make(chan bool, 1)
+	manager.DisconnectFunction = func(session *server.Session, reason server.DisconnectReason) {
+		disconnected <- true
+	}
+	if err := manager.Start("127.0.0.1", 19151); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+
+	session, err := client.Dialer{Timeout: time.Second * 5, Logger: slog.New(recordHandler{mutex: &sync.Mutex{}, records: &[]slog.Record{}})}.Dial("127.0.0.1:19151")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := handler.find("open connection request 1"); !ok {
+		t.Fatal("open connection request 1 was not logged")
+	}
+	if _, ok := handler.find("open connection request 2"); !ok {
+		t.Fatal("open connection request 2 was not logged")
+	}
+
+	session.Disconnect(server.DisconnectReasonClientQuit)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("session never disconnected")
+	}
+	attrs, ok := handler.find("session disconnected")
+	if !ok {
+		t.Fatal("session disconnect was not logged")
+	}
+	if attrs["reason"].String() != server.DisconnectReasonClientQuit.String() {
+		t.Fatal("session disconnected with reason", attrs["reason"])
+	}
+	if attrs["clientId"].Uint64() == 0 || attrs["address"].String() == "" {
+		t.Fatal("session disconnect was logged without the address and client ID of the session:", attrs)
+	}
+}
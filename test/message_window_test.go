@@ -0,0 +1,29 @@
+package test
+
+import (
+	"testing"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestMessageWindow(t *testing.T) {
+	window := server.NewMessageWindow()
+	for _, index := range []uint32{0, 2, 1, 3} {
+		if !window.Receive(index) {
+			t.Fatal("message", index, "was received for the first time, but was dropped")
+		}
+	}
+	for _, index := range []uint32{0, 2, 3} {
+		if window.Receive(index) {
+			t.Fatal("message", index, "was received twice")
+		}
+	}
+	if window.Fits(4+server.MessageWindowSize) || !window.Fits(3+server.MessageWindowSize) || !window.Fits(0) {
+		t.Fatal("window does not end", server.MessageWindowSize, "messages after the first message not received")
+	}
+	if window.Receive(4 + server.MessageWindowSize) {
+		t.Fatal("message beyond the window was accepted")
+	}
+	if !window.Receive(4) {
+		t.Fatal("message 4 was received for the first time, but was dropped")
+	}
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"testing"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func orderedPacket(reliability byte, channel byte, orderIndex uint32, sequenceIndex uint32) *protocol.EncapsulatedPacket {
+	packet := protocol.NewEncapsulatedPacket()
+	packet.Reliability = reliability
+	packet.OrderChannel = channel
+	packet.OrderIndex = orderIndex
+	packet.SequenceIndex = sequenceIndex
+	return packet
+}
+
+func TestOrderQueue(t *testing.T) {
+	queue := server.NewOrderQueue()
+	var released []uint32
+	release := func(packet *protocol.EncapsulatedPacket) {
+		released = append(released, uint32(packet.OrderChannel) << 16 | packet.OrderIndex)
+	}
+
+	queue.Order(orderedPacket(protocol.ReliabilityReliableOrdered, 0, 2, 0), release)
+	queue.Order(orderedPacket(protocol.ReliabilityReliableOrdered, 3, 0, 0), release)
+	queue.Order(orderedPacket(protocol.ReliabilityReliableOrdered, 0, 1, 0), release)
+	queue.Order(orderedPacket(protocol.ReliabilityReliableOrdered, 0, 0, 0), release)
+	queue.Order(orderedPacket(protocol.ReliabilityReliableOrdered, 0, 1, 0), release)
+
+	expected := []uint32{3 << 16, 0, 1, 2}
+	if len(released) != len(expected) {
+		t.Fatal("expected", expected, "got", released)
+	}
+	for i := range expected {
+		if released[i] != expected[i] {
+			t.Fatal("expected", expected, "got", released)
+		}
+	}
+}
+
+func TestOrderQueueSequenced(t *testing.T) {
+	queue := server.NewOrderQueue()
+	var released []uint32
+	release := func(packet *protocol.EncapsulatedPacket) {
+		released = append(released, packet.SequenceIndex)
+	}
+
+	queue.Order(orderedPacket(protocol.ReliabilityUnreliableSequenced, 1, 0, 1), release)
+	queue.Order(orderedPacket(protocol.ReliabilityUnreliableSequenced, 1, 0, 0), release)
+	queue.Order(orderedPacket(protocol.ReliabilityUnreliableSequenced, 1, 0, 3), release)
+	queue.Order(orderedPacket(protocol.ReliabilityUnreliableSequenced, 1, 0, 2), release)
+
+	if len(released) != 2 || released[0] != 1 || released[1] != 3 {
+		t.Fatal("expected [1 3], got", released)
+	}
+}
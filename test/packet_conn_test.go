@@ -0,0 +1,75 @@
+package test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/server"
+)
+
+// stringAddr is an address that is not a UDP address.
+type stringAddr string
+
+func (addr stringAddr) Network() string {
+	return "test"
+}
+
+func (addr stringAddr) String() string {
+	return string(addr)
+}
+
+// wrappedConn is a packet connection wrapping a UDP connection.
+// It counts the packets read, and reads addresses that are not UDP addresses.
+type wrappedConn struct {
+	net.PacketConn
+	reads int64
+}
+
+func (conn *wrappedConn) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	n, addr, err := conn.PacketConn.ReadFrom(buffer)
+	if err != nil {
+		return n, addr, err
+	}
+	atomic.AddInt64(&conn.reads, 1)
+	return n, stringAddr(addr.String()), nil
+}
+
+func TestPacketConn(t *testing.T) {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:19154")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn := &wrappedConn{PacketConn: udpConn}
+	manager := server.NewManager()
+	connected := make(chan *server.Session, 1)
+	manager.ConnectFunction = func(session *server.Session) {
+		connected <- session
+	}
+	manager.Serve(serverConn)
+	defer manager.Stop()
+
+	udpConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn := &wrappedConn{PacketConn: udpConn}
+	session, err := client.Dialer{Timeout: time.Second * 5}.DialPacketConn(clientConn, "127.0.0.1:19154")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.FlagForClose()
+
+	select {
+	case serverSession := <-connected:
+		if serverSession.UDPAddr.String() != udpConn.LocalAddr().String() {
+			t.Fatal("session has address", serverSession.UDPAddr, "instead of", udpConn.LocalAddr())
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("server never received the new incoming connection")
+	}
+	if atomic.LoadInt64(&serverConn.reads) == 0 || atomic.LoadInt64(&clientConn.reads) == 0 {
+		t.Fatal("packets were not read through the wrapped packet connections")
+	}
+}
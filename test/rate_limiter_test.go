@@ -0,0 +1,110 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := server.NewRateLimiter()
+	clock := server.NewVirtualClock(time.Unix(0, 0))
+	limiter.Clock = clock
+	limiter.Limits[server.PacketClassUnconnected] = server.RateLimit{Rate: 1, Burst: 3}
+	ip := net.ParseIP("10.0.0.1")
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(ip, server.PacketClassUnconnected) {
+			t.Fatal("packet", i, "within the burst was limited")
+		}
+	}
+	if limiter.Allow(ip, server.PacketClassUnconnected) {
+		t.Fatal("packet exceeding the burst was allowed")
+	}
+	if !limiter.Allow(ip, server.PacketClassHandshake) {
+		t.Fatal("packet of another class was limited")
+	}
+	if !limiter.Allow(net.ParseIP("10.0.0.2"), server.PacketClassUnconnected) {
+		t.Fatal("packet of another address was limited")
+	}
+	if stats := limiter.Stats(); stats.Allowed != 5 || stats.Limited != 1 || stats.Addresses != 2 {
+		t.Fatal("unexpected rate limiter stats:", stats)
+	}
+
+	limiter.Limits[server.PacketClassUnconnected] = server.RateLimit{Rate: 1000, Burst: 3}
+	clock.Advance(time.Millisecond * 100)
+	limiter.Prune()
+	if stats := limiter.Stats(); stats.Addresses != 0 {
+		t.Fatal("buckets of refilled addresses were not pruned:", stats.Addresses)
+	}
+}
+
+func TestPingFlood(t *testing.T) {
+	harness := loopback.NewHarness()
+	manager, err := harness.NewManager("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.RateLimiter = server.NewRateLimiter()
+	manager.RateLimiter.Clock = harness.Clock
+	manager.RateLimiter.Limits[server.PacketClassUnconnected] = server.RateLimit{Rate: 1, Burst: 5}
+	var limited []server.PacketClass
+	manager.RateLimiter.LimitFunction = func(addr *net.UDPAddr, class server.PacketClass) {
+		limited = append(limited, class)
+	}
+
+	conn, err := harness.Network.ListenPacket("10.0.0.2:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ping := protocol.NewUnconnectedPing()
+	ping.Encode()
+	for i := 0; i < 20; i++ {
+		conn.WriteTo(ping.Buffer, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132})
+	}
+	harness.Step()
+
+	if len(limited) == 0 {
+		t.Fatal("ping flood was never limited")
+	}
+	if limited[0] != server.PacketClassUnconnected {
+		t.Fatal("expected the unconnected class to be limited, got", limited[0])
+	}
+	if !manager.IsIPBlocked(conn.LocalAddr().(*net.UDPAddr)) {
+		t.Fatal("flooding address was not blocked")
+	}
+}
+
+func TestConnectionBannedReplies(t *testing.T) {
+	harness := loopback.NewHarness()
+	manager, err := harness.NewManager("10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := 0
+	harness.Filter = func(packet loopback.Packet) bool {
+		if packet.Buffer[0] == protocol.IdConnectionBanned {
+			replies++
+		}
+		return true
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 19132}
+	manager.BlockIP(addr, time.Minute)
+
+	// Blocked addresses flooding requests only get a single reply every interval.
+	request := protocol.NewOpenConnectionRequest1()
+	request.Encode()
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 20; j++ {
+			manager.HandlePacket(append([]byte{}, request.Buffer...), addr)
+		}
+		harness.Run(server.BannedReplyInterval)
+	}
+	if replies != 2 {
+		t.Fatal(replies, "connection banned replies sent for two intervals")
+	}
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func datagramWithSequence(sequenceNumber uint32) *protocol.Datagram {
+	datagram := protocol.NewDatagram()
+	datagram.SequenceNumber = sequenceNumber
+	return datagram
+}
+
+func TestReceiveWindowNAK(t *testing.T) {
+	window := server.NewReceiveWindow()
+	released := make(chan uint32, 16)
+	window.DatagramHandleFunction = func(datagram server.TimestampedDatagram) {
+		released <- datagram.SequenceNumber
+	}
+	var naks [][]uint32
+	window.NAKFunction = func(sequenceNumbers []uint32) {
+		naks = append(naks, sequenceNumbers)
+	}
+	var acks [][]uint32
+	window.ACKFunction = func(sequenceNumbers []uint32) {
+		acks = append(acks, sequenceNumbers)
+	}
+
+	for _, sequenceNumber := range []uint32{0, 2, 5} {
+		window.AddDatagram(datagramWithSequence(sequenceNumber))
+	}
+	window.Tick()
+	if len(acks) != 1 || len(acks[0]) != 3 {
+		t.Fatal("expected a single ACK for [0 2 5], got", acks)
+	}
+	if len(naks) != 1 || len(naks[0]) != 3 || naks[0][0] != 1 || naks[0][1] != 3 || naks[0][2] != 4 {
+		t.Fatal("expected a NAK for [1 3 4], got", naks)
+	}
+	window.Tick()
+	if len(naks) != 1 {
+		t.Fatal("NAK was resent within the NAK interval:", naks)
+	}
+
+	window.SkipDelay = 0
+	window.Tick()
+	releasedSequenceNumbers := map[uint32]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case sequenceNumber := <-released:
+			releasedSequenceNumbers[sequenceNumber] = true
+		case <-time.After(time.Second):
+			t.Fatal("expected datagrams 0, 2 and 5 to be released, got", releasedSequenceNumbers)
+		}
+	}
+	if !releasedSequenceNumbers[0] || !releasedSequenceNumbers[2] || !releasedSequenceNumbers[5] {
+		t.Fatal("expected datagrams 0, 2 and 5 to be released, got", releasedSequenceNumbers)
+	}
+}
+
+func TestAcknowledgementLongRanges(t *testing.T) {
+	// Ranges longer than the longest range decoded are split into several ranges when encoded.
+	ack := protocol.NewACK()
+	for i := uint32(0); i < 1500; i++ {
+		ack.Packets = append(ack.Packets, i)
+	}
+	ack.Encode()
+
+	decoded := protocol.NewACK()
+	decoded.SetBuffer(ack.Buffer)
+	if err := decoded.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Packets) != 1500 || decoded.Packets[1499] != 1499 {
+		t.Fatal("expected 1500 sequence numbers, got", len(decoded.Packets))
+	}
+}
+
+func TestAcknowledgementRanges(t *testing.T) {
+	ack := protocol.NewACK()
+	ack.Packets = []uint32{9, 1, 3, 4, 5, 5, 11, 12}
+	ack.Encode()
+
+	decoded := protocol.NewACK()
+	decoded.SetBuffer(ack.Buffer)
+	decoded.Decode()
+
+	expected := []uint32{1, 3, 4, 5, 9, 11, 12}
+	if len(decoded.Packets) != len(expected) {
+		t.Fatal("expected", expected, "got", decoded.Packets)
+	}
+	for i := range expected {
+		if decoded.Packets[i] != expected[i] {
+			t.Fatal("expected", expected, "got", decoded.Packets)
+		}
+	}
+}
+
+func TestReceiveWindowFull(t *testing.T) {
+	window := server.NewReceiveWindow()
+	added := 0
+	// The window is not ticked, so datagrams added once its channel is full are dropped instead of blocking.
+	for i := uint32(0); i < 200; i++ {
+		if window.AddDatagram(datagramWithSequence(i)) {
+			added++
+		}
+	}
+	if added == 0 || added == 200 {
+		t.Fatal(added, "of 200 datagrams added to a window that was never ticked")
+	}
+	var acks []uint32
+	window.ACKFunction = func(sequenceNumbers []uint32) {
+		acks = append(acks, sequenceNumbers...)
+	}
+	window.Tick()
+	if len(acks) != added {
+		t.Fatal("expected", added, "datagrams to be acknowledged, got", len(acks))
+	}
+}
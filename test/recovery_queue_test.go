@@ -0,0 +1,122 @@
+package test
+
+import (
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func reliableDatagram(sequenceNumber uint32) *protocol.Datagram {
+	packet := protocol.NewEncapsulatedPacket()
+	packet.Reliability = protocol.ReliabilityReliable
+	packet.Buffer = []byte{0xfe}
+	datagram := datagramWithSequence(sequenceNumber)
+	datagram.AddPacket(packet)
+	datagram.Encode()
+	return datagram
+}
+
+func TestRecoveryQueueRetransmission(t *testing.T) {
+	queue := server.NewRecoveryQueue()
+	clock := server.NewVirtualClock(time.Unix(0, 0))
+	queue.Clock = clock
+	sequenceNumber := uint32(1)
+	queue.NextSequenceNumber = func() uint32 {
+		sequenceNumber++
+		return sequenceNumber
+	}
+	queue.RetransmissionLimit = 1
+	queue.AddRecovery(reliableDatagram(1))
+
+	if datagrams, ok := queue.Tick(); !ok || len(datagrams) != 0 {
+		t.Fatal("datagram was retransmitted before its retransmission timeout expired")
+	}
+	clock.Advance(server.InitialRetransmissionTimeout)
+	datagrams, ok := queue.Tick()
+	if !ok || len(datagrams) != 1 {
+		t.Fatal("datagram was not retransmitted after its retransmission timeout expired")
+	}
+	if datagrams[0].SequenceNumber != 2 || datagrams[0].Buffer[1] != 2 {
+		t.Fatal("retransmitted datagram did not get a new sequence number")
+	}
+	if queue.IsRecoverable(1) || !queue.IsRecoverable(2) {
+		t.Fatal("retransmitted datagram was not moved to its new sequence number")
+	}
+
+	clock.Advance(server.InitialRetransmissionTimeout * 2)
+	if _, ok := queue.Tick(); ok {
+		t.Fatal("retransmission limit was exceeded without failing")
+	}
+}
+
+func TestRecoveryQueueNAK(t *testing.T) {
+	queue := server.NewRecoveryQueue()
+	queue.AddRecovery(reliableDatagram(0))
+
+	datagrams, recovered := queue.Recover([]uint32{0, 7})
+	if len(datagrams) != 1 || len(recovered) != 1 || recovered[0] != 0 {
+		t.Fatal("expected only datagram 0 to be recovered, got", recovered)
+	}
+	queue.RemoveRecovery([]uint32{datagrams[0].SequenceNumber})
+	if queue.IsRecoverable(datagrams[0].SequenceNumber) {
+		t.Fatal("ACKed datagram is still recoverable")
+	}
+}
+
+func TestRTTEstimator(t *testing.T) {
+	estimator := server.NewRTTEstimator()
+	if estimator.RetransmissionTimeout() != server.InitialRetransmissionTimeout {
+		t.Fatal("retransmission timeout without samples should be the initial retransmission timeout")
+	}
+	for _, rtt := range []time.Duration{100, 60, 140, 100} {
+		estimator.AddSample(rtt * time.Millisecond)
+	}
+	estimator.AddSample(-time.Second)
+
+	stats := estimator.Stats()
+	if stats.Samples != 4 || stats.Minimum != time.Millisecond * 60 || stats.Latest != time.Millisecond * 100 {
+		t.Fatal("unexpected RTT statistics:", stats)
+	}
+	if stats.Smoothed < time.Millisecond * 90 || stats.Smoothed > time.Millisecond * 110 {
+		t.Fatal("smoothed RTT is off:", stats.Smoothed)
+	}
+	if stats.Variance == 0 {
+		t.Fatal("RTT variance was not measured")
+	}
+}
+
+func TestRecoveryQueueWrap(t *testing.T) {
+	queue := server.NewRecoveryQueue()
+	clock := server.NewVirtualClock(time.Unix(0, 0))
+	queue.Clock = clock
+	// The sequence numbers returned exceed a triad, and should be wrapped by the queue.
+	sequenceNumber := uint32(0x1000004)
+	queue.NextSequenceNumber = func() uint32 {
+		sequenceNumber++
+		return sequenceNumber
+	}
+	// Datagrams sent right before the sequence number wrapped around are retransmitted before those sent after.
+	sent := []*protocol.Datagram{reliableDatagram(0xfffffe), reliableDatagram(0xffffff), reliableDatagram(0)}
+	for _, datagram := range sent {
+		queue.AddRecovery(datagram)
+	}
+
+	clock.Advance(server.InitialRetransmissionTimeout)
+	datagrams, ok := queue.Tick()
+	if !ok || len(datagrams) != 3 {
+		t.Fatal("datagrams were not retransmitted after their retransmission timeout expired")
+	}
+	for i, datagram := range datagrams {
+		if datagram != sent[i] {
+			t.Fatal("datagrams were not retransmitted in the order they were sent")
+		}
+		if datagram.SequenceNumber != uint32(5+i) || datagram.Buffer[3] != 0 {
+			t.Fatal("datagram", i, "was retransmitted with sequence number", datagram.SequenceNumber)
+		}
+	}
+	queue.RemoveRecovery([]uint32{5, 6, 7})
+	if queue.BytesInFlight() != 0 {
+		t.Fatal("retransmitted datagrams were not acknowledged")
+	}
+}
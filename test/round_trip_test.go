@@ -0,0 +1,233 @@
+package test
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"github.com/irmine/goraklib/protocol"
+)
+
+func FuzzUnconnectedPingRoundTrip(f *testing.F) {
+	f.Add(int64(0))
+	f.Add(int64(0xa1b2c3))
+	f.Add(int64(-1))
+	f.Fuzz(func(t *testing.T, pingTime int64) {
+		ping := protocol.NewUnconnectedPing()
+		ping.PingTime = pingTime
+		ping.Encode()
+
+		decoded := protocol.NewUnconnectedPing()
+		decoded.SetBuffer(ping.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.PingTime != pingTime {
+			t.Fatal("ping time", pingTime, "decoded as", decoded.PingTime)
+		}
+	})
+}
+
+func FuzzOpenConnectionRequest1RoundTrip(f *testing.F) {
+	f.Add(byte(11), int16(1492))
+	f.Add(byte(10), int16(1200))
+	f.Add(byte(9), int16(576))
+	f.Fuzz(func(t *testing.T, protocolVersion byte, mtuSize int16) {
+		request := protocol.NewOpenConnectionRequest1()
+		request.Encode()
+		// The MTU size is the size of the request, so that it can not be smaller than the request itself.
+		if int(mtuSize) < len(request.Buffer)+28 {
+			return
+		}
+		request.Protocol = protocolVersion
+		request.MtuSize = mtuSize
+		request.Encode()
+
+		decoded := protocol.NewOpenConnectionRequest1()
+		decoded.SetBuffer(request.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Protocol != protocolVersion || decoded.MtuSize != mtuSize {
+			t.Fatal("protocol", protocolVersion, "and MTU size", mtuSize, "decoded as", decoded.Protocol, "and", decoded.MtuSize)
+		}
+	})
+}
+
+func FuzzOpenConnectionRequest2RoundTrip(f *testing.F) {
+	f.Add(false, uint32(0), []byte{127, 0, 0, 1}, uint16(19132), int16(1492), int64(0x7a3c9e1f5d2b8406))
+	f.Add(true, uint32(0xdeadbeef), []byte(net.ParseIP("::1")), uint16(19133), int16(1200), int64(-1))
+	f.Add(true, uint32(1), []byte(net.ParseIP("192.168.1.10")), uint16(0), int16(576), int64(1))
+	f.Fuzz(func(t *testing.T, security bool, cookie uint32, ip []byte, port uint16, mtuSize int16, clientId int64) {
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			return
+		}
+		request := protocol.NewOpenConnectionRequest2()
+		request.Security = security
+		request.Cookie = cookie
+		request.ServerAddress = net.IP(ip).String()
+		request.ServerPort = port
+		request.MtuSize = mtuSize
+		request.ClientId = clientId
+		request.Encode()
+
+		decoded := protocol.NewOpenConnectionRequest2()
+		decoded.Security = security
+		decoded.SetBuffer(request.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if security && decoded.Cookie != cookie {
+			t.Fatal("cookie", cookie, "decoded as", decoded.Cookie)
+		}
+		if decoded.ServerAddress != request.ServerAddress || decoded.ServerPort != port {
+			t.Fatal("server address", request.ServerAddress, port, "decoded as", decoded.ServerAddress, decoded.ServerPort)
+		}
+		if decoded.MtuSize != mtuSize || decoded.ClientId != clientId {
+			t.Fatal("MTU size", mtuSize, "and client ID", clientId, "decoded as", decoded.MtuSize, "and", decoded.ClientId)
+		}
+	})
+}
+
+func FuzzConnectionRequestRoundTrip(f *testing.F) {
+	f.Add(uint64(0x7a3c9e1f5d2b8406), uint64(1000), byte(0))
+	f.Add(uint64(0), uint64(0), byte(1))
+	f.Fuzz(func(t *testing.T, clientId uint64, pingSendTime uint64, security byte) {
+		request := protocol.NewConnectionRequest()
+		request.ClientId = clientId
+		request.PingSendTime = pingSendTime
+		request.Security = security
+		request.Encode()
+
+		decoded := protocol.NewConnectionRequest()
+		decoded.SetBuffer(request.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.ClientId != clientId || decoded.PingSendTime != pingSendTime || decoded.Security != security {
+			t.Fatal("connection request", clientId, pingSendTime, security, "decoded as", decoded.ClientId, decoded.PingSendTime, decoded.Security)
+		}
+	})
+}
+
+// encapsulatedValues holds the values of an encapsulated packet that are written when it is encoded.
+type encapsulatedValues struct {
+	Reliability   byte
+	HasSplit      bool
+	MessageIndex  uint32
+	SequenceIndex uint32
+	OrderIndex    uint32
+	OrderChannel  byte
+	SplitId       int16
+	SplitCount    uint
+	SplitIndex    uint
+	Payload       []byte
+}
+
+func FuzzDatagramRoundTrip(f *testing.F) {
+	f.Add(uint32(0), byte(0), protocol.ReliabilityReliable, uint32(0), uint32(0), uint32(0), byte(0), false, uint32(0), uint32(0), int16(0), []byte{0x09})
+	f.Add(uint32(3), byte(protocol.BitFlagContinuousSend), protocol.ReliabilityReliableOrdered, uint32(2), uint32(1), uint32(0), byte(0), true, uint32(2), uint32(1), int16(1), []byte{0xfe, 0x78, 0xda})
+	f.Add(uint32(0xffffff), byte(protocol.BitFlagPacketPair), protocol.ReliabilityReliableSequenced, uint32(0xffffff), uint32(5), uint32(7), byte(31), false, uint32(0), uint32(0), int16(0), []byte{0x00})
+	f.Fuzz(func(t *testing.T, sequenceNumber uint32, flags byte, reliability byte, messageIndex uint32, orderIndex uint32, sequenceIndex uint32,
+		orderChannel byte, hasSplit bool, splitCount uint32, splitIndex uint32, splitId int16, payload []byte) {
+		// The length of a payload is written in bits, using an unsigned short.
+		if len(payload) == 0 || len(payload) >= 1<<13 || (hasSplit && splitIndex >= splitCount) {
+			return
+		}
+		packet := protocol.NewEncapsulatedPacket()
+		packet.Reliability = reliability & 7
+		packet.Buffer = append([]byte{}, payload...)
+		if packet.IsReliable() {
+			packet.MessageIndex = messageIndex & 0xffffff
+		}
+		if packet.IsSequenced() {
+			packet.SequenceIndex = sequenceIndex & 0xffffff
+		}
+		if packet.IsSequencedOrOrdered() {
+			packet.OrderIndex = orderIndex & 0xffffff
+			packet.OrderChannel = orderChannel
+		}
+		if hasSplit {
+			packet.HasSplit = true
+			packet.SplitCount = uint(splitCount)
+			packet.SplitIndex = uint(splitIndex)
+			packet.SplitId = splitId
+		}
+		expected := encapsulatedValues{packet.Reliability, packet.HasSplit, packet.MessageIndex, packet.SequenceIndex, packet.OrderIndex,
+			packet.OrderChannel, packet.SplitId, packet.SplitCount, packet.SplitIndex, payload}
+
+		datagram := protocol.NewDatagram()
+		datagram.SequenceNumber = sequenceNumber & 0xffffff
+		datagram.PacketPair = flags&protocol.BitFlagPacketPair != 0
+		datagram.ContinuousSend = flags&protocol.BitFlagContinuousSend != 0
+		datagram.NeedsBAndAs = flags&protocol.BitFlagNeedsBAndAs != 0
+		datagram.AddPacket(packet)
+		datagram.Encode()
+
+		decoded := protocol.NewDatagram()
+		decoded.SetBuffer(datagram.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.SequenceNumber != datagram.SequenceNumber || decoded.PacketPair != datagram.PacketPair ||
+			decoded.ContinuousSend != datagram.ContinuousSend || decoded.NeedsBAndAs != datagram.NeedsBAndAs {
+			t.Fatal("datagram header", datagram.SequenceNumber, flags, "decoded as", decoded.SequenceNumber, decoded.Buffer[0])
+		}
+		if len(*decoded.GetPackets()) != 1 {
+			t.Fatal(len(*decoded.GetPackets()), "encapsulated packets decoded")
+		}
+		decodedPacket := (*decoded.GetPackets())[0]
+		values := encapsulatedValues{decodedPacket.Reliability, decodedPacket.HasSplit, decodedPacket.MessageIndex, decodedPacket.SequenceIndex,
+			decodedPacket.OrderIndex, decodedPacket.OrderChannel, decodedPacket.SplitId, decodedPacket.SplitCount, decodedPacket.SplitIndex, decodedPacket.Buffer}
+		if !reflect.DeepEqual(values, expected) {
+			t.Fatalf("encapsulated packet %+v decoded as %+v", expected, values)
+		}
+	})
+}
+
+func FuzzAcknowledgementRoundTrip(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1})
+	f.Add([]byte{0, 0, 5, 200, 0, 1, 0, 3, 0, 1, 10, 255})
+	f.Add([]byte{0xff, 0xff, 0xf0, 255, 0, 0, 0, 255, 0, 2, 0, 255})
+	f.Fuzz(func(t *testing.T, ranges []byte) {
+		// Every four bytes are the start of a range as a triad, followed by its length divided by four,
+		// so that ranges longer than the maximum range of a single ACK are generated.
+		var sequenceNumbers []uint32
+		for i := 0; i+4 <= len(ranges) && len(sequenceNumbers) <= 4096; i += 4 {
+			start := uint32(ranges[i]) | uint32(ranges[i+1])<<8 | uint32(ranges[i+2])<<16
+			for n := uint32(0); n < uint32(ranges[i+3])*4+1 && start+n < 1<<24; n++ {
+				sequenceNumbers = append(sequenceNumbers, start+n)
+			}
+		}
+		// Sequence numbers are sorted and deduplicated when encoded.
+		sort.Slice(sequenceNumbers, func(i, j int) bool {
+			return sequenceNumbers[i] < sequenceNumbers[j]
+		})
+		var expected []uint32
+		for i, sequenceNumber := range sequenceNumbers {
+			if i == 0 || sequenceNumber != sequenceNumbers[i-1] {
+				expected = append(expected, sequenceNumber)
+			}
+		}
+		if len(expected) > 4096 {
+			return
+		}
+
+		ack := protocol.NewACK()
+		ack.Packets = append([]uint32{}, sequenceNumbers...)
+		ack.Encode()
+		decoded := protocol.NewACK()
+		decoded.SetBuffer(ack.Buffer)
+		if err := decoded.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Packets) != len(expected) {
+			t.Fatal(len(expected), "sequence numbers decoded as", len(decoded.Packets))
+		}
+		for i := range expected {
+			if decoded.Packets[i] != expected[i] {
+				t.Fatal("sequence number", expected[i], "decoded as", decoded.Packets[i])
+			}
+		}
+	})
+}
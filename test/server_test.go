@@ -1,30 +1,331 @@
 package test
 
 import (
+	"bytes"
+	"sync"
 	"testing"
-	"strconv"
-	"fmt"
 	"time"
-	"encoding/hex"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
 	"github.com/irmine/goraklib/server"
 )
 
-func Test(t *testing.T) {
-	manager := server.NewManager()
-	manager.Start("0.0.0.0", 19132)
-	manager.PongData = "MCPE;§e§lTesting §bServer;201;;0;20;" + strconv.Itoa(int(manager.ServerId)) + ";§aVersionless Minecraft Server MOTD;Creative;"
+// peer is a manager of a harness and its session,
+// which records the connects, packets and disconnect reason of the session.
+type peer struct {
+	manager  *server.Manager
+	session  *server.Session
+	connects int
+	packets  [][]byte
+	reason   server.DisconnectReason
+	closed   bool
+}
 
-	manager.PacketFunction = func(packet []byte, session *server.Session) {
-		fmt.Println("Packet:", hex.EncodeToString(packet[0:1]))
+// newPeer returns a new peer with a new manager of the harness.
+func newPeer(t *testing.T, harness *loopback.Harness, address string) *peer {
+	manager, err := harness.NewManager(address)
+	if err != nil {
+		t.Fatal(err)
 	}
+	recorder := &peer{manager: manager}
 	manager.ConnectFunction = func(session *server.Session) {
-		manager.BlockIP(session.UDPAddr, time.Second * 20)
-		fmt.Println(session, "connected!")
+		recorder.session = session
+		recorder.connects++
+	}
+	manager.PacketFunction = func(packet []byte, session *server.Session) {
+		recorder.packets = append(recorder.packets, packet)
+	}
+	manager.DisconnectFunction = func(session *server.Session, reason server.DisconnectReason) {
+		recorder.reason = reason
+		recorder.closed = true
+	}
+	return recorder
+}
+
+// connect connects a new client to a new server over the harness.
+func connect(t *testing.T, harness *loopback.Harness) (*peer, *peer) {
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+		t.Fatal(err)
+	}
+	if !harness.RunUntil(func() bool { return serverPeer.session != nil }, time.Second) {
+		t.Fatal("server never received the new incoming connection")
+	}
+	return serverPeer, clientPeer
+}
+
+func TestHarness(t *testing.T) {
+	harness := loopback.NewHarness()
+	start := harness.Clock.Now()
+	serverPeer, clientPeer := connect(t, harness)
+
+	small := rawPacket{0xfe, 1, 2, 3}
+	large := make(rawPacket, 5000)
+	large[0] = 0xfe
+	for i := range large[1:] {
+		large[i+1] = byte(i)
+	}
+	clientPeer.session.SendPacket(small, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+	clientPeer.session.SendPacket(large, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+	serverPeer.session.SendPacket(small, protocol.ReliabilityReliable, server.PriorityLow, 0)
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 2 && len(clientPeer.packets) == 1 }, time.Second) {
+		t.Fatal("packets were not received:", len(serverPeer.packets), len(clientPeer.packets))
+	}
+	if !bytes.Equal(serverPeer.packets[0], small) || !bytes.Equal(serverPeer.packets[1], large) || !bytes.Equal(clientPeer.packets[0], small) {
+		t.Fatal("packets were not received intact and in order")
+	}
+
+	clientPeer.session.Disconnect(server.DisconnectReasonClientQuit)
+	if !harness.RunUntil(func() bool { return serverPeer.closed && clientPeer.closed }, time.Second * 2) {
+		t.Fatal("sessions never closed")
+	}
+	if serverPeer.reason != server.DisconnectReasonClientQuit {
+		t.Fatal("server session closed with reason", serverPeer.reason)
+	}
+	if elapsed := harness.Clock.Now().Sub(start); elapsed > time.Second {
+		t.Fatal("handshake, exchange and disconnect took", elapsed, "of virtual time")
 	}
-	manager.DisconnectFunction = func(session *server.Session) {
-		fmt.Println(session, "disconnected!")
+}
+
+func TestHarnessLoss(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	// The first datagram holding the packet is dropped, so that it needs to be retransmitted.
+	dropped := false
+	harness.Filter = func(packet loopback.Packet) bool {
+		if !dropped && bytes.Contains(packet.Buffer, []byte{0xfe, 0xaa, 0xbb}) {
+			dropped = true
+			return false
+		}
+		return true
+	}
+	clientPeer.session.SendPacket(rawPacket{0xfe, 0xaa, 0xbb}, protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	// A second packet is sent, so that the gap in sequence numbers is found and NAKed.
+	clientPeer.session.SendPacket(rawPacket{0xfe, 0xcc}, protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 2 }, time.Second * 2) {
+		t.Fatal("lost packet was never retransmitted")
+	}
+	if !dropped || !bytes.Equal(serverPeer.packets[0], []byte{0xfe, 0xaa, 0xbb}) {
+		t.Fatal("packets were not received in order after the loss")
 	}
+	if stats := clientPeer.session.Stats(); stats.Retransmissions == 0 || stats.NAKsReceived == 0 {
+		t.Fatal("loss was not recovered by a NAK:", stats.Counters)
+	}
+}
+
+func TestHarnessReplyLoss(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
 
-	time.Sleep(time.Minute)
-	manager.Stop()
+	// The first open connection reply 2 is dropped, so that the request is retried for the session already created.
+	dropped := false
+	harness.Filter = func(packet loopback.Packet) bool {
+		if !dropped && packet.Buffer[0] == protocol.IdOpenConnectionReply2 {
+			dropped = true
+			return false
+		}
+		return true
+	}
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != nil {
+		t.Fatal(err)
+	}
+	if !dropped || serverPeer.manager.Sessions.Count() != 1 {
+		t.Fatal("handshake did not recover from the lost reply")
+	}
+}
+
+func TestHarnessDialTimeout(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	clientPeer := newPeer(t, harness, "10.0.0.2:0")
+
+	// Datagrams sent to the server are dropped, so that it never receives the connection request.
+	harness.Filter = func(packet loopback.Packet) bool {
+		return packet.To.Port != 19132 || packet.Buffer[0]&0x80 == 0
+	}
+	if _, err := harness.Dial(clientPeer.manager, "10.0.0.1:19132"); err != client.TimedOut {
+		t.Fatal("expected the dial to time out, got", err)
+	}
+	if !clientPeer.closed || clientPeer.reason != server.DisconnectReasonTimeout || clientPeer.manager.Sessions.Count() != 0 {
+		t.Fatal("pending session was not closed once the dial timed out")
+	}
+	harness.Filter = nil
+	harness.Run(time.Second)
+	if clientPeer.connects != 0 || serverPeer.connects != 0 {
+		t.Fatal("session connected after the dial timed out")
+	}
+}
+
+func TestHarnessConcurrentSend(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	// Packets are sent by multiple goroutines at once, so that every packet needs unique indexes to be received.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				packet := make(rawPacket, 1+j*300)
+				packet[0] = 0xfe
+				clientPeer.session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, byte(i))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 80 }, time.Second * 2) {
+		t.Fatal("packets sent concurrently were not received:", len(serverPeer.packets))
+	}
+}
+
+func TestHarnessDisconnectTwice(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	notifications := 0
+	harness.Filter = func(packet loopback.Packet) bool {
+		flags := packet.Buffer[0]
+		if flags&protocol.BitFlagValid != 0 && flags&(protocol.BitFlagIsAck|protocol.BitFlagIsNak) == 0 &&
+			packet.Buffer[len(packet.Buffer)-1] == protocol.IdDisconnectNotification {
+			notifications++
+		}
+		return true
+	}
+	serverPeer.session.Disconnect(server.DisconnectReasonKicked)
+	serverPeer.session.Disconnect(server.DisconnectReasonShutdown)
+	if !harness.RunUntil(func() bool { return serverPeer.closed && clientPeer.closed }, time.Second * 2) {
+		t.Fatal("sessions never closed")
+	}
+	if notifications != 1 || serverPeer.reason != server.DisconnectReasonKicked {
+		t.Fatal(notifications, "disconnect notifications sent, and session closed with reason", serverPeer.reason)
+	}
+}
+
+func TestHarnessConnectOnce(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	// Connection accepts are only handled by dialed sessions, and sessions only connect once.
+	clientPeer.session.SendPacket(protocol.NewConnectionAccept(), protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	clientPeer.session.SendPacket(protocol.NewNewIncomingConnection(), protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	serverPeer.session.SendPacket(protocol.NewConnectionAccept(), protocol.ReliabilityReliableOrdered, server.PriorityImmediate, 0)
+	harness.Run(time.Millisecond * 100)
+	if serverPeer.connects != 1 || clientPeer.connects != 1 {
+		t.Fatal("server connected", serverPeer.connects, "times, and client", clientPeer.connects, "times")
+	}
+}
+
+func TestHarnessTimeout(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	harness.Filter = func(packet loopback.Packet) bool {
+		return false
+	}
+	timeout := serverPeer.manager.TimeoutDuration
+	harness.Run(timeout - time.Second)
+	if serverPeer.closed || clientPeer.closed {
+		t.Fatal("sessions closed before timing out")
+	}
+	if !harness.RunUntil(func() bool { return serverPeer.closed && clientPeer.closed }, time.Second * 2) {
+		t.Fatal("sessions never timed out")
+	}
+	if serverPeer.reason != server.DisconnectReasonTimeout || clientPeer.reason != server.DisconnectReasonTimeout {
+		t.Fatal("sessions closed with reasons", serverPeer.reason, clientPeer.reason)
+	}
+}
+
+func TestHarnessDeterministic(t *testing.T) {
+	// trace runs the same exchange over a new harness, and returns the sizes of all packets delivered.
+	trace := func() []int {
+		harness := loopback.NewHarness()
+		var sizes []int
+		harness.Filter = func(packet loopback.Packet) bool {
+			sizes = append(sizes, len(packet.Buffer))
+			// Every seventh packet is dropped.
+			return len(sizes)%7 != 0
+		}
+		serverPeer, clientPeer := connect(t, harness)
+		for i := 0; i < 20; i++ {
+			packet := make(rawPacket, 100*i+1)
+			packet[0] = 0xfe
+			clientPeer.session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityMedium, 0)
+			harness.Step()
+		}
+		if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 20 }, time.Second * 5) {
+			t.Fatal("packets were not received:", len(serverPeer.packets))
+		}
+		return sizes
+	}
+	first, second := trace(), trace()
+	if len(first) != len(second) {
+		t.Fatal("runs delivered", len(first), "and", len(second), "packets")
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatal("runs differ at packet", i)
+		}
+	}
+}
+
+func TestHarnessCloseUnblocksSend(t *testing.T) {
+	harness := loopback.NewHarness()
+	_, clientPeer := connect(t, harness)
+
+	// The harness is not stepped, so that the send queue fills up and the sender blocks until the session closes.
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 300; i++ {
+			clientPeer.session.SendPacket(rawPacket{0xfe}, protocol.ReliabilityReliableOrdered, server.PriorityLow, 0)
+		}
+		close(sent)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	clientPeer.session.Close()
+	select {
+	case <-sent:
+	case <-time.After(time.Second * 5):
+		t.Fatal("sender blocked on the full queue of a closed session")
+	}
+}
+
+func TestHarnessMessageWindowHole(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer, clientPeer := connect(t, harness)
+
+	// The first message is lost until no messages were received for longer than the skip delay of the receive window,
+	// which happens once the messages following it filled the message window,
+	// so that messages beyond the window need to be resent once the hole is filled.
+	// The hole is resent more often than the retransmission limit allows while it is lost.
+	clientPeer.session.RecoveryQueue.RetransmissionLimit = 1000
+	hole := rawPacket{0xfe, 0xaa, 0xbb, 0xcc}
+	received, changed := 0, harness.Clock.Now()
+	harness.Filter = func(packet loopback.Packet) bool {
+		if now := harness.Clock.Now(); len(serverPeer.packets) != received {
+			received, changed = len(serverPeer.packets), now
+		} else if now.Sub(changed) >= server.DefaultSkipDelay * 3 {
+			return true
+		}
+		return !bytes.Contains(packet.Buffer, hole)
+	}
+	clientPeer.session.SendPacket(hole, protocol.ReliabilityReliable, server.PriorityMedium, 0)
+	count := server.MessageWindowSize + 500
+	for i := 0; i < count; i++ {
+		// The harness is stepped while the queue is almost full, so that sending never blocks.
+		for clientPeer.session.Stats().QueueDepths[server.PriorityMedium] > 200 {
+			harness.Step()
+		}
+		clientPeer.session.SendPacket(rawPacket{0xfe, 0x01, byte(i >> 8), byte(i)}, protocol.ReliabilityReliable, server.PriorityMedium, 0)
+	}
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == count+1 }, time.Second * 30) {
+		t.Fatal(len(serverPeer.packets), "of", count+1, "messages received after the hole was filled")
+	}
+	if serverPeer.closed || clientPeer.closed {
+		t.Fatal("sessions closed while the hole was held")
+	}
 }
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestSessionManager(t *testing.T) {
+	manager := server.NewManager()
+	sessions := server.NewSessionManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := server.NewSession(&net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 19132}, 1400, manager)
+			session.ClientId = uint64(i + 1)
+			sessions.AddSession(session)
+			sessions.GetSessions()
+		}(i)
+	}
+	wg.Wait()
+
+	if sessions.Count() != 64 || len(sessions.GetSessions()) != 64 {
+		t.Fatal("expected 64 sessions, got", sessions.Count())
+	}
+	session, ok := sessions.GetSession(&net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.5"), Port: 19132})
+	if !ok || session.ClientId != 6 {
+		t.Fatal("session could not be found by its IPv4-mapped address")
+	}
+	if byClientId, ok := sessions.GetSessionByClientId(6); !ok || byClientId != session {
+		t.Fatal("session could not be found by its client ID")
+	}
+
+	sessions.RemoveSession(session)
+	if sessions.SessionExists(session.UDPAddr) || sessions.Count() != 63 {
+		t.Fatal("session was not removed")
+	}
+	if _, ok := sessions.GetSessionByClientId(6); ok {
+		t.Fatal("session could still be found by its client ID after removal")
+	}
+}
+
+func TestSessionManagerClientId(t *testing.T) {
+	manager := server.NewManager()
+	sessions := server.NewSessionManager()
+	first := server.NewSession(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132}, 1400, manager)
+	second := server.NewSession(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 19132}, 1400, manager)
+	sessions.AddSession(first)
+	sessions.AddSession(second)
+
+	first.ClientId = 1
+	if !sessions.IndexClientId(first) {
+		t.Fatal("session was not indexed by its client ID")
+	}
+	first.ClientId = 2
+	if !sessions.IndexClientId(first) {
+		t.Fatal("session was not indexed by its new client ID")
+	}
+	if _, ok := sessions.GetSessionByClientId(1); ok {
+		t.Fatal("session could still be found by its previous client ID")
+	}
+
+	second.ClientId = 2
+	if sessions.IndexClientId(second) {
+		t.Fatal("client ID of an open session was taken over by another session")
+	}
+	if session, ok := sessions.GetSessionByClientId(2); !ok || session != first {
+		t.Fatal("client ID no longer finds the session owning it")
+	}
+	sessions.RemoveSession(first)
+	if !sessions.IndexClientId(second) {
+		t.Fatal("client ID of a removed session could not be taken over")
+	}
+	sessions.RemoveSession(second)
+	if sessions.IndexClientId(second) {
+		t.Fatal("session was indexed after being removed")
+	}
+}
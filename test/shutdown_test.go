@@ -0,0 +1,57 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/client"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestShutdown(t *testing.T) {
+	manager := server.NewManager()
+	disconnected := make(chan server.DisconnectReason, 1)
+	manager.DisconnectFunction = func(session *server.Session, reason server.DisconnectReason) {
+		disconnected <- reason
+	}
+
+	// The manager is started twice, to make sure it can be restarted after shutting down.
+	for i := 0; i < 2; i++ {
+		if err := manager.Start("127.0.0.1", 19143); err != nil {
+			t.Fatal(err)
+		}
+		clientDisconnected := make(chan server.DisconnectReason, 1)
+		_, err := client.Dialer{Timeout: time.Second * 5, DisconnectFunction: func(session *server.Session, reason server.DisconnectReason) {
+			clientDisconnected <- reason
+		}}.Dial("127.0.0.1:19143")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+		err = manager.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manager.Sessions.Count() != 0 {
+			t.Fatal("sessions left over after shutting down:", manager.Sessions.Count())
+		}
+		select {
+		case reason := <-disconnected:
+			if reason != server.DisconnectReasonShutdown {
+				t.Fatal("server session disconnected with reason", reason)
+			}
+		default:
+			t.Fatal("disconnect function was not called for the session of the server")
+		}
+		select {
+		case reason := <-clientDisconnected:
+			if reason != server.DisconnectReasonClientQuit {
+				t.Fatal("client session disconnected with reason", reason)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("client never received the disconnect notification")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"github.com/irmine/goraklib/loopback"
+	"github.com/irmine/goraklib/protocol"
+	"github.com/irmine/goraklib/server"
+)
+
+func TestStats(t *testing.T) {
+	harness := loopback.NewHarness()
+	serverPeer := newPeer(t, harness, "10.0.0.1:19132")
+	manager := serverPeer.manager
+	session, err := harness.Dial(newPeer(t, harness, "10.0.0.2:0").manager, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The packet is larger than the MTU size, so that it gets split and reassembled.
+	packet := make(rawPacket, 4000)
+	packet[0] = 0xfe
+	session.SendPacket(packet, protocol.ReliabilityReliableOrdered, server.PriorityHigh, 0)
+	if !harness.RunUntil(func() bool { return len(serverPeer.packets) == 1 }, time.Second) {
+		t.Fatal("server never received the split packet")
+	}
+	// ACKs are sent on the next tick of the receive window.
+	harness.Step()
+
+	stats := manager.Stats()
+	if len(stats.Sessions) != 1 {
+		t.Fatal("expected stats of 1 session, got", len(stats.Sessions))
+	}
+	sessionStats := stats.Sessions[0]
+	if sessionStats.SplitReassemblies != 1 {
+		t.Fatal("expected 1 split reassembly, got", sessionStats.SplitReassemblies)
+	}
+	if sessionStats.DatagramsReceived < 3 || sessionStats.BytesReceived < 4000 || sessionStats.ACKsSent == 0 {
+		t.Fatal("session counters not updated:", sessionStats.Counters)
+	}
+	if stats.DatagramsReceived < sessionStats.DatagramsReceived || stats.BytesSent < sessionStats.BytesSent || stats.BytesReceived < sessionStats.BytesReceived {
+		t.Fatal("manager counters lower than those of its session:", stats.Counters, sessionStats.Counters)
+	}
+	if clientStats := session.Stats(); clientStats.ACKsReceived == 0 || clientStats.DatagramsSent < 3 {
+		t.Fatal("client session counters not updated:", clientStats.Counters)
+	}
+
+	// metrics returns the body served by the metrics handler of the manager.
+	metrics := func() string {
+		recorder := httptest.NewRecorder()
+		manager.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+	// Series of sessions are only exported once enabled, so that peers can not create series by connecting.
+	body := metrics()
+	for _, line := range []string{
+		"# TYPE goraklib_datagrams_received_total counter",
+		"goraklib_sessions 1",
+		"goraklib_queue_depth{priority=\"immediate\"} 0",
+		"goraklib_bytes_in_flight ",
+		"goraklib_rtt_seconds{stat=\"mean\"} ",
+		"goraklib_rtt_seconds{stat=\"max\"} ",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatal("metrics are missing", line, "\n"+body)
+		}
+	}
+	if strings.Contains(body, "address=") {
+		t.Fatal("metrics of sessions exported by default\n" + body)
+	}
+	manager.MetricsMaxSessions = 1
+	body = metrics()
+	for _, line := range []string{
+		"goraklib_session_split_reassemblies_total{address=\"" + sessionStats.Address + "\",client_id=\"",
+		"goraklib_session_queue_depth{address=\"" + sessionStats.Address,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatal("metrics are missing", line, "\n"+body)
+		}
+	}
+}